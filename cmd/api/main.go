@@ -13,6 +13,7 @@ import (
 
 	"dbpiper/database"
 	"dbpiper/internal/databases/pgx"
+	"dbpiper/internal/syncer"
 	"dbpiper/server"
 )
 
@@ -46,10 +47,22 @@ func main() {
   pgPool := pgx.New()
   defer pgPool.Close()
 
+	db := database.New()
+	runner := syncer.New(db, pgPool)
+
+	// Background queue processing, scheduling and change capture stop once
+	// main returns
+	workerCtx, stopWorker := context.WithCancel(context.Background())
+	defer stopWorker()
+	go syncer.NewWorker(runner).Run(workerCtx)
+	go syncer.NewScheduler(runner).Run(workerCtx)
+	go syncer.NewCapture(runner).Run(workerCtx)
+
 	serv := &server.Server{
 		Port: port,
     PgxPool: pgPool,
-		DB: database.New(),
+		DB: db,
+		Runner: runner,
 	}
 	
   server := server.NewServer(serv)
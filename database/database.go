@@ -4,9 +4,11 @@ import (
 	"context"
 	"database/sql"
 	"dbpiper/database/models"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -32,6 +34,61 @@ type DB interface {
 	GetDatabaseConnectionByID(ctx context.Context, userID, id string) (*models.DatabaseConnection, error)
 	GetAirtableConnectionByID(ctx context.Context, userID, id string) (*models.AirtableConnection, error)
 	CreateSync(ctx context.Context, sync *models.Sync) error
+	GetSyncByID(ctx context.Context, userID, id string) (*models.Sync, error)
+	FindSyncByID(ctx context.Context, id string) (*models.Sync, error)
+	ClaimSyncRun(ctx context.Context, id string, statuses []models.SyncStatus, staleBefore time.Time) (bool, error)
+	HeartbeatSyncRun(ctx context.Context, id string) error
+	FinishSyncRun(ctx context.Context, id string) error
+	GetInterruptedSyncs(ctx context.Context, staleBefore time.Time) ([]models.Sync, error)
+	GetCaptureSyncs(ctx context.Context, statuses []models.SyncStatus) ([]models.Sync, error)
+	UpdateSyncCaptureMode(ctx context.Context, id string, mode models.CaptureMode) error
+	GetScheduledSyncs(ctx context.Context, statuses []models.SyncStatus, dueBefore time.Time) ([]models.Sync, error)
+	SetSyncNextRun(ctx context.Context, id string, prev, next *time.Time) (bool, error)
+	UpdateSyncSchedule(ctx context.Context, userID, id, schedule, timezone string) error
+	UpdateSyncStatus(ctx context.Context, id string, status models.SyncStatus, lastError sql.NullString) error
+	DeleteSync(ctx context.Context, userID, id string) error
+	GetSyncsByConnection(ctx context.Context, userID string, repo models.RepoType, connID string) ([]models.Sync, error)
+	GetBackfillProgress(ctx context.Context, syncID string) ([]models.BackfillProgress, error)
+	SaveBackfillProgress(ctx context.Context, progress *models.BackfillProgress) error
+	ResetBackfillProgress(ctx context.Context, syncID, table string) error
+	StartBackfillPass(ctx context.Context, syncID string) error
+	GetRecordLinksByKeys(ctx context.Context, syncID, table string, keys []string) ([]models.RecordLink, error)
+	GetRecordLinksByRecordIDs(ctx context.Context, syncID, table string, recordIDs []string) ([]models.RecordLink, error)
+	SaveRecordLinks(ctx context.Context, links []models.RecordLink) error
+	GetRecordLinks(ctx context.Context, syncID, table string) ([]models.RecordLink, error)
+	TombstoneRecordLinks(ctx context.Context, ids []int, from models.RepoType) error
+	GetPendingTombstones(ctx context.Context, syncID, table string) ([]models.RecordLink, error)
+	MarkTombstonesPropagated(ctx context.Context, ids []int) error
+	SaveSyncConflict(ctx context.Context, conflict *models.SyncConflict) error
+	GetSyncConflicts(ctx context.Context, syncID string, status models.ConflictStatus) ([]models.SyncConflict, error)
+	GetSyncConflictByID(ctx context.Context, syncID string, id int) (*models.SyncConflict, error)
+	ResolveSyncConflict(ctx context.Context, id int, winner models.RepoType) error
+	EnqueueWebhook(ctx context.Context, item *models.WebhookQueue) error
+	HasPendingQueueItem(ctx context.Context, syncID, source string) (bool, error)
+	ClaimWebhookQueueItem(ctx context.Context) (*models.WebhookQueue, error)
+	ClaimPendingQueueItems(ctx context.Context, syncID, source string, exceptID, limit int) ([]models.WebhookQueue, error)
+	DeleteWebhookQueueItem(ctx context.Context, id int) error
+	DeleteWebhookQueueItems(ctx context.Context, ids []int) error
+	LeaseWebhookQueueItems(ctx context.Context, ids []int, until time.Time) error
+	ReleaseWebhookQueueItems(ctx context.Context, ids []int) error
+	RetryWebhookQueueItem(ctx context.Context, id, attempts int, nextRetryAt time.Time, lastError string) error
+	DeadLetterWebhookQueueItem(ctx context.Context, id, attempts int, lastError string) error
+	GetDeadLetters(ctx context.Context, syncID, table string) ([]models.WebhookQueue, error)
+	GetDeadLetterByID(ctx context.Context, syncID string, id int) (*models.WebhookQueue, error)
+	ReplayDeadLetter(ctx context.Context, syncID string, id int) (bool, error)
+	ReplayDeadLetters(ctx context.Context, syncID, table string) (int64, error)
+	DeleteDeadLetter(ctx context.Context, syncID string, id int) (bool, error)
+	CreateSyncRun(ctx context.Context, run *models.SyncRun) error
+	UpdateSyncRun(ctx context.Context, run *models.SyncRun) error
+	GetSyncRuns(ctx context.Context, syncID string, limit int) ([]models.SyncRun, error)
+	GetSyncRunByID(ctx context.Context, syncID string, id int) (*models.SyncRun, error)
+
+	GetAirtableWebhooks(ctx context.Context, syncID string) ([]models.AirtableWebhook, error)
+	GetAirtableWebhookByWebhookID(ctx context.Context, webhookID string) (*models.AirtableWebhook, error)
+	SaveAirtableWebhook(ctx context.Context, webhook *models.AirtableWebhook) error
+	DeleteAirtableWebhook(ctx context.Context, id int) error
+	AdvanceWebhookCursor(ctx context.Context, id int, from, to int64) (bool, error)
+	GetExpiringAirtableWebhooks(ctx context.Context, before time.Time) ([]models.AirtableWebhook, error)
 }
 
 type service struct {
@@ -63,6 +120,12 @@ func New() DB {
 		&models.AirtableConnection{},
 		&models.DatabaseConnection{},
 		&models.Sync{},
+		&models.BackfillProgress{},
+		&models.RecordLink{},
+		&models.SyncConflict{},
+		&models.WebhookQueue{},
+		&models.SyncRun{},
+		&models.AirtableWebhook{},
 	)
 
 	if err != nil {
@@ -189,3 +252,669 @@ func (s *service) CreateSync(ctx context.Context, sync *models.Sync) error {
 		Model(&models.Sync{}).
 		Create(sync).Error
 }
+
+func (s *service) GetSyncByID(ctx context.Context, userID, id string) (*models.Sync, error) {
+	var sync models.Sync
+	if err := s.db.WithContext(ctx).
+		Model(&models.Sync{}).
+		Where(idAndUserId, id, userID).
+		First(&sync).Error; err != nil {
+		return nil, err
+	}
+	return &sync, nil
+}
+
+// FindSyncByID loads a sync without scoping it to a user. It is meant for
+// background work (the sync runner) which only knows the sync ID.
+func (s *service) FindSyncByID(ctx context.Context, id string) (*models.Sync, error) {
+	var sync models.Sync
+	if err := s.db.WithContext(ctx).
+		Model(&models.Sync{}).
+		Where("id = ?", id).
+		First(&sync).Error; err != nil {
+		return nil, err
+	}
+	return &sync, nil
+}
+
+// ClaimSyncRun marks a sync as running if it is in one of the given states
+// and no other run holds it. A run whose last heartbeat is before
+// staleBefore is assumed to have died with its process and may be taken
+// over. It reports whether the claim succeeded, which lets concurrent
+// runners race for the same sync.
+func (s *service) ClaimSyncRun(ctx context.Context, id string, statuses []models.SyncStatus, staleBefore time.Time) (bool, error) {
+	now := time.Now()
+	res := s.db.WithContext(ctx).
+		Model(&models.Sync{}).
+		Where("id = ? AND status IN ? AND (run_started_at IS NULL OR run_heartbeat_at IS NULL OR run_heartbeat_at < ?)", id, statuses, staleBefore).
+		Updates(map[string]any{
+			"run_started_at":   now,
+			"run_heartbeat_at": now,
+			"updated_at":       now,
+		})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected > 0, nil
+}
+
+func (s *service) HeartbeatSyncRun(ctx context.Context, id string) error {
+	return s.db.WithContext(ctx).
+		Model(&models.Sync{}).
+		Where("id = ? AND run_started_at IS NOT NULL", id).
+		Update("run_heartbeat_at", time.Now()).Error
+}
+
+func (s *service) FinishSyncRun(ctx context.Context, id string) error {
+	return s.db.WithContext(ctx).
+		Model(&models.Sync{}).
+		Where("id = ?", id).
+		Updates(map[string]any{
+			"run_started_at":   nil,
+			"run_heartbeat_at": nil,
+		}).Error
+}
+
+// GetInterruptedSyncs returns syncs whose run stopped beating before
+// staleBefore without finishing.
+func (s *service) GetInterruptedSyncs(ctx context.Context, staleBefore time.Time) ([]models.Sync, error) {
+	var syncs []models.Sync
+	if err := s.db.WithContext(ctx).
+		Model(&models.Sync{}).
+		Where("run_started_at IS NOT NULL AND (run_heartbeat_at IS NULL OR run_heartbeat_at < ?)", staleBefore).
+		Find(&syncs).Error; err != nil {
+		return nil, err
+	}
+	return syncs, nil
+}
+
+// GetScheduledSyncs returns syncs with a schedule in one of the given states
+// whose next run is due, or not yet computed.
+func (s *service) GetScheduledSyncs(ctx context.Context, statuses []models.SyncStatus, dueBefore time.Time) ([]models.Sync, error) {
+	var syncs []models.Sync
+	if err := s.db.WithContext(ctx).
+		Model(&models.Sync{}).
+		Where("schedule <> '' AND status IN ? AND (next_run_at IS NULL OR next_run_at <= ?)", statuses, dueBefore).
+		Find(&syncs).Error; err != nil {
+		return nil, err
+	}
+	return syncs, nil
+}
+
+// GetCaptureSyncs returns the syncs in one of the given states that capture
+// Postgres changes.
+func (s *service) GetCaptureSyncs(ctx context.Context, statuses []models.SyncStatus) ([]models.Sync, error) {
+	var syncs []models.Sync
+	if err := s.db.WithContext(ctx).
+		Model(&models.Sync{}).
+		Where("capture_mode <> '' AND status IN ?", statuses).
+		Find(&syncs).Error; err != nil {
+		return nil, err
+	}
+	return syncs, nil
+}
+
+func (s *service) UpdateSyncCaptureMode(ctx context.Context, id string, mode models.CaptureMode) error {
+	return s.db.WithContext(ctx).
+		Model(&models.Sync{}).
+		Where("id = ?", id).
+		Updates(map[string]any{
+			"capture_mode": mode,
+			"updated_at":   time.Now(),
+		}).Error
+}
+
+// SetSyncNextRun moves next_run_at from prev to next. It reports false if
+// another scheduler changed it first.
+func (s *service) SetSyncNextRun(ctx context.Context, id string, prev, next *time.Time) (bool, error) {
+	q := s.db.WithContext(ctx).
+		Model(&models.Sync{}).
+		Where("id = ?", id)
+	if prev == nil {
+		q = q.Where("next_run_at IS NULL")
+	} else {
+		q = q.Where("next_run_at = ?", *prev)
+	}
+	res := q.Update("next_run_at", next)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected > 0, nil
+}
+
+// UpdateSyncSchedule changes the schedule and lets the scheduler compute the
+// next run from it.
+func (s *service) UpdateSyncSchedule(ctx context.Context, userID, id, schedule, timezone string) error {
+	return s.db.WithContext(ctx).
+		Model(&models.Sync{}).
+		Where(idAndUserId, id, userID).
+		Updates(map[string]any{
+			"schedule":    schedule,
+			"timezone":    timezone,
+			"next_run_at": nil,
+			"updated_at":  time.Now(),
+		}).Error
+}
+
+func (s *service) UpdateSyncStatus(ctx context.Context, id string, status models.SyncStatus, lastError sql.NullString) error {
+	return s.db.WithContext(ctx).
+		Model(&models.Sync{}).
+		Where("id = ?", id).
+		Updates(map[string]any{
+			"status":     status,
+			"last_error": lastError,
+			"updated_at": time.Now(),
+		}).Error
+}
+
+// GetSyncsByConnection returns the syncs of a user with connection connID
+// of type repo on either side.
+func (s *service) GetSyncsByConnection(ctx context.Context, userID string, repo models.RepoType, connID string) ([]models.Sync, error) {
+	var syncs []models.Sync
+	if err := s.db.WithContext(ctx).
+		Model(&models.Sync{}).
+		Where("user_id = ?", userID).
+		Where("(source_type = ? AND source_conn_id = ?) OR (target_type = ? AND target_conn_id = ?)", repo, connID, repo, connID).
+		Find(&syncs).Error; err != nil {
+		return nil, err
+	}
+	return syncs, nil
+}
+
+// DeleteSync removes a sync with everything recorded about it.
+func (s *service) DeleteSync(ctx context.Context, userID, id string) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Where(idAndUserId, id, userID).Delete(&models.Sync{})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		for _, model := range []any{
+			&models.BackfillProgress{},
+			&models.RecordLink{},
+			&models.SyncConflict{},
+			&models.SyncRun{},
+		} {
+			if err := tx.Where("sync_id = ?", id).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *service) GetBackfillProgress(ctx context.Context, syncID string) ([]models.BackfillProgress, error) {
+	var progress []models.BackfillProgress
+	if err := s.db.WithContext(ctx).
+		Model(&models.BackfillProgress{}).
+		Where("sync_id = ?", syncID).
+		Order("id").
+		Find(&progress).Error; err != nil {
+		return nil, err
+	}
+	return progress, nil
+}
+
+func (s *service) SaveBackfillProgress(ctx context.Context, progress *models.BackfillProgress) error {
+	progress.UpdatedAt = time.Now()
+	return s.db.WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "sync_id"}, {Name: "source_table"}},
+			DoUpdates: clause.AssignmentColumns([]string{"rows_read", "rows_written", "cursor", "high_water", "next_high_water", "swept_at", "completed", "updated_at"}),
+		}).
+		Create(progress).Error
+}
+
+// ResetBackfillProgress forgets how far a table got, or every table when
+// table is empty, so the next run copies it again from the start.
+func (s *service) ResetBackfillProgress(ctx context.Context, syncID, table string) error {
+	q := s.db.WithContext(ctx).Where("sync_id = ?", syncID)
+	if table != "" {
+		q = q.Where("source_table = ?", table)
+	}
+	return q.Delete(&models.BackfillProgress{}).Error
+}
+
+// StartBackfillPass marks every table of a sync to be read again. High-water
+// marks are kept, so tables read by cursor column only read what changed.
+func (s *service) StartBackfillPass(ctx context.Context, syncID string) error {
+	return s.db.WithContext(ctx).
+		Model(&models.BackfillProgress{}).
+		Where("sync_id = ?", syncID).
+		Updates(map[string]any{
+			"rows_read":    0,
+			"rows_written": 0,
+			"cursor":       nil,
+			"completed":    false,
+			"updated_at":   time.Now(),
+		}).Error
+}
+
+func (s *service) GetRecordLinksByKeys(ctx context.Context, syncID, table string, keys []string) ([]models.RecordLink, error) {
+	var links []models.RecordLink
+	if err := s.db.WithContext(ctx).
+		Model(&models.RecordLink{}).
+		Where("sync_id = ? AND source_table = ? AND primary_key IN ?", syncID, table, keys).
+		Find(&links).Error; err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+func (s *service) GetRecordLinksByRecordIDs(ctx context.Context, syncID, table string, recordIDs []string) ([]models.RecordLink, error) {
+	var links []models.RecordLink
+	if err := s.db.WithContext(ctx).
+		Model(&models.RecordLink{}).
+		Where("sync_id = ? AND source_table = ? AND record_id IN ?", syncID, table, recordIDs).
+		Find(&links).Error; err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+// SaveRecordLinks stores links, replacing any link that already uses one of
+// their keys or record IDs so the map stays one-to-one.
+func (s *service) SaveRecordLinks(ctx context.Context, links []models.RecordLink) error {
+	if len(links) == 0 {
+		return nil
+	}
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, l := range links {
+			if err := tx.
+				Where("sync_id = ? AND source_table = ? AND (primary_key = ? OR record_id = ?)",
+					l.SyncID, l.SourceTable, l.PrimaryKey, l.RecordID).
+				Delete(&models.RecordLink{}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(&links).Error
+	})
+}
+
+func (s *service) GetRecordLinks(ctx context.Context, syncID, table string) ([]models.RecordLink, error) {
+	var links []models.RecordLink
+	if err := s.db.WithContext(ctx).
+		Model(&models.RecordLink{}).
+		Where("sync_id = ? AND source_table = ?", syncID, table).
+		Find(&links).Error; err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+// TombstoneRecordLinks marks links whose row or record was deleted from one
+// side. Links already tombstoned keep their first tombstone.
+func (s *service) TombstoneRecordLinks(ctx context.Context, ids []int, from models.RepoType) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	now := time.Now()
+	return s.db.WithContext(ctx).
+		Model(&models.RecordLink{}).
+		Where("id IN ? AND tombstoned_at IS NULL", ids).
+		Updates(map[string]any{
+			"tombstoned_at": now,
+			"deleted_from":  from,
+			"propagated":    false,
+			"updated_at":    now,
+		}).Error
+}
+
+// GetPendingTombstones returns the tombstones of a table whose delete has
+// not been applied to the other side yet.
+func (s *service) GetPendingTombstones(ctx context.Context, syncID, table string) ([]models.RecordLink, error) {
+	var links []models.RecordLink
+	if err := s.db.WithContext(ctx).
+		Model(&models.RecordLink{}).
+		Where("sync_id = ? AND source_table = ? AND tombstoned_at IS NOT NULL AND NOT propagated", syncID, table).
+		Order("id").
+		Find(&links).Error; err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+func (s *service) MarkTombstonesPropagated(ctx context.Context, ids []int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return s.db.WithContext(ctx).
+		Model(&models.RecordLink{}).
+		Where("id IN ?", ids).
+		Updates(map[string]any{
+			"propagated": true,
+			"updated_at": time.Now(),
+		}).Error
+}
+
+// SaveSyncConflict records a pending conflict, refreshing the values of the
+// one already pending for the same record if there is one.
+func (s *service) SaveSyncConflict(ctx context.Context, conflict *models.SyncConflict) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var existing models.SyncConflict
+		err := tx.
+			Where("sync_id = ? AND source_table = ? AND record_id = ? AND status = ?",
+				conflict.SyncID, conflict.SourceTable, conflict.RecordID, models.ConflictPending).
+			First(&existing).Error
+		if err == gorm.ErrRecordNotFound {
+			conflict.Status = models.ConflictPending
+			return tx.Create(conflict).Error
+		}
+		if err != nil {
+			return err
+		}
+		conflict.ID = existing.ID
+		return tx.Model(&existing).Updates(map[string]any{
+			"primary_key":     conflict.PrimaryKey,
+			"pg_values":       conflict.PgValues,
+			"airtable_values": conflict.AirtableValues,
+			"updated_at":      time.Now(),
+		}).Error
+	})
+}
+
+func (s *service) GetSyncConflicts(ctx context.Context, syncID string, status models.ConflictStatus) ([]models.SyncConflict, error) {
+	var conflicts []models.SyncConflict
+	q := s.db.WithContext(ctx).
+		Model(&models.SyncConflict{}).
+		Where("sync_id = ?", syncID)
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+	if err := q.Order("id").Find(&conflicts).Error; err != nil {
+		return nil, err
+	}
+	return conflicts, nil
+}
+
+func (s *service) GetSyncConflictByID(ctx context.Context, syncID string, id int) (*models.SyncConflict, error) {
+	var conflict models.SyncConflict
+	if err := s.db.WithContext(ctx).
+		Model(&models.SyncConflict{}).
+		Where("id = ? AND sync_id = ?", id, syncID).
+		First(&conflict).Error; err != nil {
+		return nil, err
+	}
+	return &conflict, nil
+}
+
+func (s *service) ResolveSyncConflict(ctx context.Context, id int, winner models.RepoType) error {
+	now := time.Now()
+	return s.db.WithContext(ctx).
+		Model(&models.SyncConflict{}).
+		Where("id = ?", id).
+		Updates(map[string]any{
+			"status":      models.ConflictResolved,
+			"resolution":  winner,
+			"resolved_at": now,
+			"updated_at":  now,
+		}).Error
+}
+
+func (s *service) EnqueueWebhook(ctx context.Context, item *models.WebhookQueue) error {
+	return s.db.WithContext(ctx).
+		Omit("Sync").
+		Create(item).Error
+}
+
+// HasPendingQueueItem reports whether a sync has a row from source that is
+// still to be handled, due or not.
+func (s *service) HasPendingQueueItem(ctx context.Context, syncID, source string) (bool, error) {
+	var n int64
+	err := s.db.WithContext(ctx).
+		Model(&models.WebhookQueue{}).
+		Where("sync_id = ? AND source = ? AND dead_at IS NULL", syncID, source).
+		Limit(1).
+		Count(&n).Error
+	return n > 0, err
+}
+
+// ClaimWebhookQueueItem locks the next due queue row, skipping rows other
+// workers hold. Call it inside WithTx: the lock lasts until the transaction
+// ends. It returns nil when nothing is due.
+func (s *service) ClaimWebhookQueueItem(ctx context.Context) (*models.WebhookQueue, error) {
+	var items []models.WebhookQueue
+	if err := s.db.WithContext(ctx).
+		Model(&models.WebhookQueue{}).
+		Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("dead_at IS NULL AND (next_retry_at IS NULL OR next_retry_at <= ?)", time.Now()).
+		Order("COALESCE(next_retry_at, created_at), id").
+		Limit(1).
+		Find(&items).Error; err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, nil
+	}
+	return &items[0], nil
+}
+
+// ClaimPendingQueueItems locks the rows of a sync from one source that have
+// not been tried yet, due or not, skipping rows other workers hold. Like
+// ClaimWebhookQueueItem it must run inside WithTx.
+func (s *service) ClaimPendingQueueItems(ctx context.Context, syncID, source string, exceptID, limit int) ([]models.WebhookQueue, error) {
+	var items []models.WebhookQueue
+	if err := s.db.WithContext(ctx).
+		Model(&models.WebhookQueue{}).
+		Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("sync_id = ? AND source = ? AND id <> ? AND dead_at IS NULL AND attempts = 0", syncID, source, exceptID).
+		Order("id").
+		Limit(limit).
+		Find(&items).Error; err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (s *service) DeleteWebhookQueueItem(ctx context.Context, id int) error {
+	return s.db.WithContext(ctx).
+		Delete(&models.WebhookQueue{}, "id = ?", id).Error
+}
+
+func (s *service) DeleteWebhookQueueItems(ctx context.Context, ids []int) error {
+	return s.db.WithContext(ctx).
+		Delete(&models.WebhookQueue{}, "id IN ?", ids).Error
+}
+
+// LeaseWebhookQueueItems counts an attempt on claimed rows and keeps them
+// from being claimed again until the lease runs out.
+func (s *service) LeaseWebhookQueueItems(ctx context.Context, ids []int, until time.Time) error {
+	return s.db.WithContext(ctx).
+		Model(&models.WebhookQueue{}).
+		Where("id IN ?", ids).
+		Updates(map[string]any{
+			"attempts":      gorm.Expr("attempts + 1"),
+			"next_retry_at": until,
+		}).Error
+}
+
+// ReleaseWebhookQueueItems ends the lease of rows that were not attempted
+// after all, making them due again with no attempt counted.
+func (s *service) ReleaseWebhookQueueItems(ctx context.Context, ids []int) error {
+	return s.db.WithContext(ctx).
+		Model(&models.WebhookQueue{}).
+		Where("id IN ?", ids).
+		Updates(map[string]any{
+			"attempts":      gorm.Expr("attempts - 1"),
+			"next_retry_at": nil,
+		}).Error
+}
+
+func (s *service) RetryWebhookQueueItem(ctx context.Context, id, attempts int, nextRetryAt time.Time, lastError string) error {
+	return s.db.WithContext(ctx).
+		Model(&models.WebhookQueue{}).
+		Where("id = ?", id).
+		Updates(map[string]any{
+			"attempts":      attempts,
+			"next_retry_at": nextRetryAt,
+			"last_error":    sql.NullString{String: lastError, Valid: lastError != ""},
+		}).Error
+}
+
+func (s *service) DeadLetterWebhookQueueItem(ctx context.Context, id, attempts int, lastError string) error {
+	return s.db.WithContext(ctx).
+		Model(&models.WebhookQueue{}).
+		Where("id = ?", id).
+		Updates(map[string]any{
+			"attempts":      attempts,
+			"next_retry_at": nil,
+			"dead_at":       time.Now(),
+			"last_error":    sql.NullString{String: lastError, Valid: lastError != ""},
+		}).Error
+}
+
+// deadLetters scopes a query to the dead letters of a sync, optionally only
+// those with an event for the given table.
+func (s *service) deadLetters(ctx context.Context, syncID, table string) *gorm.DB {
+	q := s.db.WithContext(ctx).
+		Model(&models.WebhookQueue{}).
+		Where("sync_id = ? AND dead_at IS NOT NULL", syncID)
+	if table != "" {
+		filter, _ := json.Marshal([]map[string]string{{"table": table}})
+		q = q.Where("payload->'events' @> ?", string(filter))
+	}
+	return q
+}
+
+func (s *service) GetDeadLetters(ctx context.Context, syncID, table string) ([]models.WebhookQueue, error) {
+	var items []models.WebhookQueue
+	if err := s.deadLetters(ctx, syncID, table).
+		Order("id").
+		Find(&items).Error; err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (s *service) GetDeadLetterByID(ctx context.Context, syncID string, id int) (*models.WebhookQueue, error) {
+	var item models.WebhookQueue
+	if err := s.deadLetters(ctx, syncID, "").
+		Where("id = ?", id).
+		First(&item).Error; err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// replayUpdates puts a dead letter back in the queue with fresh attempts.
+func replayUpdates() map[string]any {
+	return map[string]any{
+		"attempts":      0,
+		"dead_at":       nil,
+		"next_retry_at": nil,
+	}
+}
+
+// ReplayDeadLetter reports false when the sync has no such dead letter.
+func (s *service) ReplayDeadLetter(ctx context.Context, syncID string, id int) (bool, error) {
+	res := s.deadLetters(ctx, syncID, "").
+		Where("id = ?", id).
+		Updates(replayUpdates())
+	return res.RowsAffected > 0, res.Error
+}
+
+func (s *service) ReplayDeadLetters(ctx context.Context, syncID, table string) (int64, error) {
+	res := s.deadLetters(ctx, syncID, table).Updates(replayUpdates())
+	return res.RowsAffected, res.Error
+}
+
+func (s *service) DeleteDeadLetter(ctx context.Context, syncID string, id int) (bool, error) {
+	res := s.deadLetters(ctx, syncID, "").
+		Where("id = ?", id).
+		Delete(&models.WebhookQueue{})
+	return res.RowsAffected > 0, res.Error
+}
+
+func (s *service) CreateSyncRun(ctx context.Context, run *models.SyncRun) error {
+	return s.db.WithContext(ctx).Create(run).Error
+}
+
+func (s *service) UpdateSyncRun(ctx context.Context, run *models.SyncRun) error {
+	return s.db.WithContext(ctx).Save(run).Error
+}
+
+// GetSyncRuns returns the latest runs of a sync, newest first.
+func (s *service) GetSyncRuns(ctx context.Context, syncID string, limit int) ([]models.SyncRun, error) {
+	var runs []models.SyncRun
+	if err := s.db.WithContext(ctx).
+		Model(&models.SyncRun{}).
+		Where("sync_id = ?", syncID).
+		Order("started_at DESC, id DESC").
+		Limit(limit).
+		Find(&runs).Error; err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+func (s *service) GetSyncRunByID(ctx context.Context, syncID string, id int) (*models.SyncRun, error) {
+	var run models.SyncRun
+	if err := s.db.WithContext(ctx).
+		Model(&models.SyncRun{}).
+		Where("id = ? AND sync_id = ?", id, syncID).
+		First(&run).Error; err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+func (s *service) GetAirtableWebhooks(ctx context.Context, syncID string) ([]models.AirtableWebhook, error) {
+	var webhooks []models.AirtableWebhook
+	if err := s.db.WithContext(ctx).
+		Model(&models.AirtableWebhook{}).
+		Where("sync_id = ?", syncID).
+		Order("id").
+		Find(&webhooks).Error; err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+func (s *service) GetAirtableWebhookByWebhookID(ctx context.Context, webhookID string) (*models.AirtableWebhook, error) {
+	var webhook models.AirtableWebhook
+	if err := s.db.WithContext(ctx).
+		Model(&models.AirtableWebhook{}).
+		Where("webhook_id = ?", webhookID).
+		First(&webhook).Error; err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+func (s *service) SaveAirtableWebhook(ctx context.Context, webhook *models.AirtableWebhook) error {
+	return s.db.WithContext(ctx).Save(webhook).Error
+}
+
+// AdvanceWebhookCursor moves the payload cursor of a webhook from one value
+// to another. It reports false when the cursor was no longer at from, i.e.
+// another worker read the same payloads first.
+func (s *service) AdvanceWebhookCursor(ctx context.Context, id int, from, to int64) (bool, error) {
+	res := s.db.WithContext(ctx).
+		Model(&models.AirtableWebhook{}).
+		Where("id = ? AND cursor = ?", id, from).
+		Updates(map[string]any{
+			"cursor":     to,
+			"updated_at": time.Now(),
+		})
+	return res.RowsAffected == 1, res.Error
+}
+
+func (s *service) DeleteAirtableWebhook(ctx context.Context, id int) error {
+	return s.db.WithContext(ctx).Delete(&models.AirtableWebhook{}, id).Error
+}
+
+// GetExpiringAirtableWebhooks returns the webhooks that expire before the
+// given time.
+func (s *service) GetExpiringAirtableWebhooks(ctx context.Context, before time.Time) ([]models.AirtableWebhook, error) {
+	var webhooks []models.AirtableWebhook
+	if err := s.db.WithContext(ctx).
+		Model(&models.AirtableWebhook{}).
+		Where("expires_at < ?", before).
+		Order("expires_at").
+		Find(&webhooks).Error; err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// AirtableWebhook is a webhook dbpiper keeps on the Airtable base of a
+// sync that reads from Airtable, one per mapped table.
+type AirtableWebhook struct {
+	ID      int    `gorm:"primaryKey"`
+	SyncID  string `gorm:"type:uuid;uniqueIndex:idx_webhook_sync_table;not null"`
+	Sync    Sync   `gorm:"constraint:OnDelete:CASCADE"`
+	TableID string `gorm:"uniqueIndex:idx_webhook_sync_table;not null"`
+
+	WebhookID string `gorm:"uniqueIndex;not null"`
+	// Key of the HMAC Airtable signs pings with, base64 encoded
+	MACSecret string `gorm:"not null"`
+
+	// Airtable drops the webhook unless it is refreshed before then
+	ExpiresAt time.Time `gorm:"index"`
+
+	// Number of the next payload to read; Airtable numbers them from 1
+	Cursor int64 `gorm:"not null;default:1"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
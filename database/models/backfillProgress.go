@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+)
+
+// BackfillProgress tracks the initial copy of one table of a sync so an
+// interrupted backfill can pick up where it stopped.
+type BackfillProgress struct {
+	ID          int       `gorm:"primaryKey"`
+	SyncID      uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_backfill_sync_table;not null"`
+	SourceTable string    `gorm:"uniqueIndex:idx_backfill_sync_table;not null"`
+
+	RowsRead    int64
+	RowsWritten int64
+
+	// Primary key of the last row written, as a JSON array of text values.
+	Cursor datatypes.JSON
+
+	// Tables read by cursor column start each pass from HighWater, the mark
+	// NextHighWater held when the previous pass started
+	HighWater     string
+	NextHighWater string
+	// When the links of the table were last checked for deleted rows
+	SweptAt *time.Time
+
+	Completed bool
+	UpdatedAt time.Time
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RecordLink pairs a Postgres row with the Airtable record it is synced to.
+// PrimaryKey holds the row's primary key as a JSON array of text values.
+type RecordLink struct {
+	ID          int       `gorm:"primaryKey"`
+	SyncID      uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_record_link_key;uniqueIndex:idx_record_link_record"`
+	SourceTable string    `gorm:"not null;uniqueIndex:idx_record_link_key;uniqueIndex:idx_record_link_record"`
+	PrimaryKey  string    `gorm:"not null;uniqueIndex:idx_record_link_key"`
+	RecordID    string    `gorm:"not null;uniqueIndex:idx_record_link_record"`
+
+	// Fingerprints of the mapped values on each side as of the last sync,
+	// used to tell which side changed since.
+	PgHash       string
+	AirtableHash string
+
+	// Tombstone: set once the row or record is gone from DeletedFrom. The
+	// link is kept so the delete is applied to the other side even across
+	// restarts, and so the surviving side is not copied back.
+	TombstonedAt *time.Time `gorm:"index"`
+	DeletedFrom  RepoType
+	Propagated   bool
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
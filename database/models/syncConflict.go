@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+)
+
+type ConflictStatus string
+
+const (
+	ConflictPending  ConflictStatus = "pending"
+	ConflictResolved ConflictStatus = "resolved"
+)
+
+// SyncConflict is a record changed on both sides of a two way sync that the
+// sync's ConflictPolicy held for manual review.
+type SyncConflict struct {
+	ID          int       `gorm:"primaryKey"`
+	SyncID      uuid.UUID `gorm:"type:uuid;index;not null"`
+	SourceTable string    `gorm:"not null"`
+	PrimaryKey  string    `gorm:"not null"`
+	RecordID    string    `gorm:"not null"`
+
+	// Both sides keyed by Airtable field ID
+	PgValues       datatypes.JSON
+	AirtableValues datatypes.JSON
+
+	Status     ConflictStatus `gorm:"type:varchar(20);index;not null"`
+	Resolution RepoType       // winning side once resolved
+	ResolvedAt *time.Time
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
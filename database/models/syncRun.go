@@ -0,0 +1,52 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+)
+
+type RunTrigger string
+
+const (
+	TriggerManual   RunTrigger = "manual"
+	TriggerSchedule RunTrigger = "schedule"
+	TriggerResume   RunTrigger = "resume" // picks up a run that died
+	// catches up after Airtable webhook payloads were lost
+	TriggerReconcile RunTrigger = "reconcile"
+)
+
+type RunStatus string
+
+const (
+	RunRunning   RunStatus = "running"
+	RunSucceeded RunStatus = "succeeded"
+	RunFailed    RunStatus = "failed"
+)
+
+// SyncRun is one execution of a sync by the runner.
+type SyncRun struct {
+	ID      int        `gorm:"primaryKey"`
+	SyncID  uuid.UUID  `gorm:"type:uuid;index;not null"`
+	Trigger RunTrigger `gorm:"type:varchar(20);not null"`
+	Status  RunStatus  `gorm:"type:varchar(20);not null"`
+
+	Tables        datatypes.JSON // []TableRunStats
+	AirtableCalls int64
+	Error         sql.NullString
+
+	StartedAt  time.Time `gorm:"index"`
+	FinishedAt *time.Time
+}
+
+// TableRunStats counts what a run did to one table mapping.
+type TableRunStats struct {
+	Table       string `json:"table"`
+	RowsRead    int64  `json:"rows_read"`
+	RowsCreated int64  `json:"rows_created"`
+	RowsUpdated int64  `json:"rows_updated"`
+	RowsDeleted int64  `json:"rows_deleted"`
+	RowsFailed  int64  `json:"rows_failed"`
+}
@@ -18,6 +18,8 @@ const (
 
 type SyncStatus string
 type RepoType string
+type ConflictPolicy string
+type CaptureMode string
 
 const (
 	SyncSetup      SyncStatus = "setup"
@@ -32,6 +34,21 @@ const (
 	Airtable RepoType = "airtable"
 )
 
+// What a two way sync does with a record changed on both sides.
+const (
+	LastWriterWins ConflictPolicy = "last_writer_wins"
+	PostgresWins   ConflictPolicy = "postgres_wins"
+	AirtableWins   ConflictPolicy = "airtable_wins"
+	ManualReview   ConflictPolicy = "manual_review"
+)
+
+// How changes on the Postgres side reach a sync between runs.
+const (
+	CaptureNone        CaptureMode = ""            // runs only
+	CaptureReplication CaptureMode = "replication" // logical replication slot
+	CaptureTrigger     CaptureMode = "trigger"     // triggers and a changelog table
+)
+
 type Sync struct {
 	ID     uuid.UUID `gorm:"type:uuid;primaryKey"`
 	UserID string    `gorm:"index;not null"`
@@ -47,6 +64,12 @@ type Sync struct {
 	// Direction
 	Direction SyncDirection // "one_way" | "two_way"
 
+	// Postgres change capture; needs Postgres as source or a two way sync
+	CaptureMode CaptureMode `gorm:"type:varchar(20)"`
+
+	// Only used by two way syncs
+	ConflictPolicy ConflictPolicy `gorm:"type:varchar(20);default:'last_writer_wins'"`
+
 	Tables datatypes.JSON
 	/*
 	  [
@@ -61,9 +84,19 @@ type Sync struct {
 	  ]
 	*/
 
+	// Optional schedule: "@every 15m", "0 2 * * *", ... evaluated in Timezone
+	Schedule  string
+	Timezone  string
+	NextRunAt *time.Time `gorm:"index"`
+
 	// State
 	Status SyncStatus // setup | active | paused | error
 
+	// Set while a run is in progress; a run that stops beating is assumed
+	// to have died with its process
+	RunStartedAt   *time.Time
+	RunHeartbeatAt *time.Time
+
 	LastError sql.NullString
 
 	CreatedAt time.Time
@@ -1,21 +1,34 @@
 package models
 
 import (
+	"database/sql"
 	"time"
 
 	"gorm.io/datatypes"
 )
 
+// Where a queued payload came from.
+const (
+	QueueSourceAirtable = "airtable"
+	QueueSourceDatabase = "database"
+	QueueSourceSchedule = "schedule"
+	// a ping of an Airtable webhook; the changes are read from its payloads
+	QueueSourceAirtableWebhook = "airtable_webhook"
+)
+
 type WebhookQueue struct {
-    ID          int            `gorm:"primaryKey"`
-    SyncID      string         `gorm:"type:uuid;index"`
-    Sync        Sync           `gorm:"constraint:OnDelete:CASCADE"`
+	ID     int    `gorm:"primaryKey"`
+	SyncID string `gorm:"type:uuid;index"`
+	Sync   Sync   `gorm:"constraint:OnDelete:CASCADE"`
 
-    Payload     datatypes.JSON // JSONB
-    Source      string         // airtable or database
-    Attempts    int            `gorm:"default:0"`
-    MaxAttempts int            `gorm:"default:3"`
+	Payload     datatypes.JSON // JSONB
+	Source      string         // airtable, database, schedule or airtable_webhook
+	Attempts    int            `gorm:"default:0"`
+	MaxAttempts int            `gorm:"default:3"`
+	LastError   sql.NullString
 
-    NextRetryAt *time.Time
-    CreatedAt   time.Time      `gorm:"autoCreateTime"`
+	NextRetryAt *time.Time `gorm:"index"`
+	// Set once MaxAttempts is reached; the row is kept for replay.
+	DeadAt    *time.Time `gorm:"index"`
+	CreatedAt time.Time  `gorm:"autoCreateTime"`
 }
@@ -12,14 +12,20 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
 const (
-	metaBasesURL = "https://api.airtable.com/v0/meta/bases"
+	apiURL       = "https://api.airtable.com/v0"
 	authorizeURL = "https://airtable.com/oauth2/v1/authorize"
 	tokenURL     = "https://airtable.com/oauth2/v1/token"
-	tableBase    = "https://api.airtable.com/v0/meta/bases/%s/tables"
+
+	// paths under the API URL
+	metaBasesPath = "/meta/bases"
+	tablesPath    = "/meta/bases/%s/tables"
+	recordsPath   = "/%s/%s"
+	webhooksPath  = "/bases/%s/webhooks"
 )
 
 type Client interface {
@@ -30,40 +36,74 @@ type Client interface {
 	GetRedirectURL() string
 	SetAirtableConnection(conn *models.AirtableConnection)
 	GetTables(ctx context.Context) ([]types.Table, error)
+	ListRecords(ctx context.Context, tableID string, params types.ListRecordsParams) (*types.RecordPage, error)
+	GetRecord(ctx context.Context, tableID, recordID string, params types.GetRecordParams) (*types.Record, error)
+	CreateRecords(ctx context.Context, tableID string, records []types.Record, typecast bool) ([]types.Record, error)
+	UpdateRecords(ctx context.Context, tableID string, records []types.Record, typecast bool) ([]types.Record, error)
+	ReplaceRecords(ctx context.Context, tableID string, records []types.Record, typecast bool) ([]types.Record, error)
+	UpsertRecords(ctx context.Context, tableID string, records []types.Record, opts types.UpsertOptions) ([]types.UpsertedRecord, error)
+	DeleteRecords(ctx context.Context, tableID string, recordIDs []string) ([]string, error)
+	CreateWebhook(ctx context.Context, tableID string) (*types.Webhook, error)
+	RefreshWebhook(ctx context.Context, webhookID string) (time.Time, error)
+	DeleteWebhook(ctx context.Context, webhookID string) error
+	ListWebhookPayloads(ctx context.Context, webhookID string, cursor int64) (*types.WebhookPayloads, error)
 }
 
 type Airtable struct {
 	ClientID     string
 	ClientSecret string
+	APIURL       string
 	AuthURL      string
 	TokenURL     string
 	CallbackURI  string
 	RedirectURI  string
+	WebhookURI   string
+	HTTPClient   *http.Client
+	Requests     *atomic.Int64 // counts API requests when set
 	DB           *database.DB
 	Conn         *models.AirtableConnection
+
+	transport http.RoundTripper // set by WithTransport
 }
 
-func New(db *database.DB, conn *models.AirtableConnection) Client {
+func New(db *database.DB, conn *models.AirtableConnection, opts ...Option) Client {
 	base := os.Getenv("APP_BASE_URL")
-	return &Airtable{
+	a := &Airtable{
 		ClientID:     os.Getenv("AIRTABLE_CLIENT_ID"),
 		ClientSecret: os.Getenv("AIRTABLE_CLIENT_SECRET"),
+		APIURL:       apiURL,
 		AuthURL:      authorizeURL,
 		TokenURL:     tokenURL,
 		CallbackURI:  strings.TrimRight(base, "/") + "/api/v1/airtable/oauth/callback",
 		RedirectURI:  strings.TrimRight(base, "/") + "/connections",
+		WebhookURI:   strings.TrimRight(base, "/") + "/api/v1/webhooks/airtable",
+		HTTPClient:   httpClient,
 		DB:           db,
 		Conn:         conn,
 	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	if a.transport != nil {
+		c := *a.HTTPClient
+		c.Transport = a.transport
+		a.HTTPClient = &c
+	}
+	return a
+}
+
+// apiURLf formats a path under the API URL.
+func (a *Airtable) apiURLf(path string, args ...any) string {
+	return a.APIURL + fmt.Sprintf(path, args...)
 }
 
 func (a *Airtable) CheckApiKey(ctx context.Context, baseID, apiKey string) error {
-	url := fmt.Sprintf(tableBase, baseID)
+	url := a.apiURLf(tablesPath, baseID)
 
 	httpReq, _ := http.NewRequestWithContext(ctx, "GET", url, nil)
 	httpReq.Header.Set("Authorization", "Bearer "+apiKey)
 
-	resp, err := http.DefaultClient.Do(httpReq)
+	resp, err := a.HTTPClient.Do(httpReq)
 	if err != nil {
 		return err
 	}
@@ -88,7 +128,7 @@ func (a *Airtable) GetBases(ctx context.Context) ([]types.Base, error) {
 	var data struct {
 		Bases []types.Base `json:"bases"`
 	}
-	if err := a.doRequest(ctx, "GET", metaBasesURL, nil, &data); err != nil {
+	if err := a.doRequest(ctx, "GET", a.apiURLf(metaBasesPath), nil, &data); err != nil {
 		return nil, err
 	}
 	return data.Bases, nil
@@ -97,7 +137,7 @@ func (a *Airtable) GetTables(ctx context.Context) ([]types.Table, error) {
 	var data struct {
 		Tables []types.Table `json:"tables"`
 	}
-	if err := a.doRequest(ctx, "GET", fmt.Sprintf(tableBase, a.Conn.BaseID), nil, &data); err != nil {
+	if err := a.doRequest(ctx, "GET", a.apiURLf(tablesPath, a.Conn.BaseID), nil, &data); err != nil {
 		return nil, err
 	}
 
@@ -161,7 +201,7 @@ func (c *Airtable) OauthCallback(ctx context.Context, state, code string) (*mode
 	req.SetBasicAuth(c.ClientID, c.ClientSecret)
 	req.Header.Set("User-Agent", "Mozilla/5.0 dbpiper/1.0")
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := c.HTTPClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
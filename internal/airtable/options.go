@@ -0,0 +1,58 @@
+package airtable
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// Option changes how a client New returns talks to Airtable, for instance
+// to go through a proxy or to a fake server in tests.
+type Option func(*Airtable)
+
+// WithAPIURL sends API calls to url instead of https://api.airtable.com/v0.
+func WithAPIURL(url string) Option {
+	return func(a *Airtable) {
+		a.APIURL = strings.TrimRight(url, "/")
+	}
+}
+
+// WithOAuthURLs replaces the OAuth authorize and token endpoints. Empty
+// arguments keep the default.
+func WithOAuthURLs(authorize, token string) Option {
+	return func(a *Airtable) {
+		if authorize != "" {
+			a.AuthURL = authorize
+		}
+		if token != "" {
+			a.TokenURL = token
+		}
+	}
+}
+
+// WithHTTPClient makes every request, OAuth ones included, with c. A nil
+// c keeps the shared default.
+func WithHTTPClient(c *http.Client) Option {
+	return func(a *Airtable) {
+		if c != nil {
+			a.HTTPClient = c
+		}
+	}
+}
+
+// WithTransport sends requests through rt, keeping the other settings of
+// the HTTP client, like its timeout. It applies to the client given by
+// WithHTTPClient whatever order the two come in.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(a *Airtable) {
+		a.transport = rt
+	}
+}
+
+// WithRequestCounter adds every API request the client sends to n, each
+// retry and each chunk of a batch included.
+func WithRequestCounter(n *atomic.Int64) Option {
+	return func(a *Airtable) {
+		a.Requests = n
+	}
+}
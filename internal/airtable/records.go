@@ -0,0 +1,265 @@
+package airtable
+
+import (
+	"context"
+	"dbpiper/types"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+)
+
+const (
+	// MaxRecordsPerRequest is the number of records Airtable accepts in a
+	// single create/update/delete call.
+	MaxRecordsPerRequest = 10
+	// MaxPageSize is the largest page Airtable returns when listing records.
+	MaxPageSize = 100
+)
+
+func (a *Airtable) recordsURL(tableID string) string {
+	return a.apiURLf(recordsPath, a.Conn.BaseID, url.PathEscape(tableID))
+}
+
+// ListRecords returns one page of records. Pass the returned Offset back in
+// params to fetch the next page; it is empty on the last page.
+func (a *Airtable) ListRecords(ctx context.Context, tableID string, params types.ListRecordsParams) (*types.RecordPage, error) {
+	q := url.Values{}
+	if params.PageSize > 0 {
+		q.Set("pageSize", strconv.Itoa(params.PageSize))
+	}
+	if params.Offset != "" {
+		q.Set("offset", params.Offset)
+	}
+	for _, f := range params.Fields {
+		q.Add("fields[]", f)
+	}
+	if params.FilterByFormula != "" {
+		q.Set("filterByFormula", params.FilterByFormula)
+	}
+	if params.ReturnFieldsByFieldID {
+		q.Set("returnFieldsByFieldId", "true")
+	}
+	if params.View != "" {
+		q.Set("view", params.View)
+	}
+	for i, s := range params.Sort {
+		direction := s.Direction
+		if direction == "" {
+			direction = types.SortAsc
+		}
+		q.Set(fmt.Sprintf("sort[%d][field]", i), s.Field)
+		q.Set(fmt.Sprintf("sort[%d][direction]", i), string(direction))
+	}
+	if err := setCellFormat(q, params.CellFormat, params.TimeZone, params.UserLocale); err != nil {
+		return nil, err
+	}
+
+	u := a.recordsURL(tableID)
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+
+	var page types.RecordPage
+	if err := a.doRequest(ctx, "GET", u, nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// GetRecord fetches one record. A record that does not exist fails with an
+// error IsNotFound recognises.
+func (a *Airtable) GetRecord(ctx context.Context, tableID, recordID string, params types.GetRecordParams) (*types.Record, error) {
+	q := url.Values{}
+	if params.ReturnFieldsByFieldID {
+		q.Set("returnFieldsByFieldId", "true")
+	}
+	if err := setCellFormat(q, params.CellFormat, params.TimeZone, params.UserLocale); err != nil {
+		return nil, err
+	}
+
+	u := a.recordsURL(tableID) + "/" + url.PathEscape(recordID)
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+
+	var record types.Record
+	if err := a.doRequest(ctx, "GET", u, nil, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// setCellFormat adds the cell format options to q. Airtable only formats
+// cells as strings for a given time zone and locale.
+func setCellFormat(q url.Values, cellFormat, timeZone, userLocale string) error {
+	switch cellFormat {
+	case "", "json":
+	case "string":
+		if timeZone == "" || userLocale == "" {
+			return fmt.Errorf("cellFormat string needs a timeZone and userLocale")
+		}
+	default:
+		return fmt.Errorf("unknown cellFormat %q", cellFormat)
+	}
+	if cellFormat != "" {
+		q.Set("cellFormat", cellFormat)
+	}
+	if timeZone != "" {
+		q.Set("timeZone", timeZone)
+	}
+	if userLocale != "" {
+		q.Set("userLocale", userLocale)
+	}
+	return nil
+}
+
+func (a *Airtable) CreateRecords(ctx context.Context, tableID string, records []types.Record, typecast bool) ([]types.Record, error) {
+	create := make([]types.Record, len(records))
+	for i, r := range records {
+		// only fields may be sent on create
+		create[i] = types.Record{Fields: r.Fields}
+	}
+	return a.writeRecords(ctx, "POST", tableID, create, typecast)
+}
+
+// UpdateRecords patches the given fields of existing records, leaving the
+// other fields untouched.
+func (a *Airtable) UpdateRecords(ctx context.Context, tableID string, records []types.Record, typecast bool) ([]types.Record, error) {
+	update := make([]types.Record, len(records))
+	for i, r := range records {
+		if r.ID == "" {
+			return nil, fmt.Errorf("record %d has no id", i)
+		}
+		update[i] = types.Record{ID: r.ID, Fields: r.Fields}
+	}
+	return a.writeRecords(ctx, "PATCH", tableID, update, typecast)
+}
+
+// ReplaceRecords overwrites existing records: fields that are not given
+// are cleared.
+func (a *Airtable) ReplaceRecords(ctx context.Context, tableID string, records []types.Record, typecast bool) ([]types.Record, error) {
+	replace := make([]types.Record, len(records))
+	for i, r := range records {
+		if r.ID == "" {
+			return nil, fmt.Errorf("record %d has no id", i)
+		}
+		replace[i] = types.Record{ID: r.ID, Fields: r.Fields}
+	}
+	return a.writeRecords(ctx, "PUT", tableID, replace, typecast)
+}
+
+// UpsertRecords writes any number of records, MaxRecordsPerRequest at a
+// time. Records with an ID update it; the others update the record whose
+// FieldsToMergeOn values they share, or are created. Results come back in
+// request order. When a request fails, the results of the requests before
+// it are returned with the error.
+func (a *Airtable) UpsertRecords(ctx context.Context, tableID string, records []types.Record, opts types.UpsertOptions) ([]types.UpsertedRecord, error) {
+	if len(opts.FieldsToMergeOn) == 0 {
+		return nil, fmt.Errorf("upsert needs at least one field to merge on")
+	}
+
+	upsert := make([]types.Record, len(records))
+	for i, r := range records {
+		upsert[i] = types.Record{ID: r.ID, Fields: r.Fields}
+	}
+
+	results := make([]types.UpsertedRecord, 0, len(records))
+	for chunk := range slices.Chunk(upsert, MaxRecordsPerRequest) {
+		payload := struct {
+			PerformUpsert struct {
+				FieldsToMergeOn []string `json:"fieldsToMergeOn"`
+			} `json:"performUpsert"`
+			Records               []types.Record `json:"records"`
+			Typecast              bool           `json:"typecast,omitempty"`
+			ReturnFieldsByFieldID bool           `json:"returnFieldsByFieldId"`
+		}{
+			Records:               chunk,
+			Typecast:              opts.Typecast,
+			ReturnFieldsByFieldID: true,
+		}
+		payload.PerformUpsert.FieldsToMergeOn = opts.FieldsToMergeOn
+
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return results, err
+		}
+
+		var data struct {
+			Records        []types.Record `json:"records"`
+			CreatedRecords []string       `json:"createdRecords"`
+		}
+		if err := a.doRequest(ctx, "PATCH", a.recordsURL(tableID), body, &data); err != nil {
+			return results, err
+		}
+		for _, rec := range data.Records {
+			results = append(results, types.UpsertedRecord{
+				Record:  rec,
+				Created: slices.Contains(data.CreatedRecords, rec.ID),
+			})
+		}
+	}
+	return results, nil
+}
+
+func (a *Airtable) writeRecords(ctx context.Context, method, tableID string, records []types.Record, typecast bool) ([]types.Record, error) {
+	if len(records) > MaxRecordsPerRequest {
+		return nil, fmt.Errorf("airtable accepts at most %d records per request, got %d", MaxRecordsPerRequest, len(records))
+	}
+
+	// fields come back keyed by ID so they line up with what was written
+	payload := struct {
+		Records               []types.Record `json:"records"`
+		Typecast              bool           `json:"typecast,omitempty"`
+		ReturnFieldsByFieldID bool           `json:"returnFieldsByFieldId"`
+	}{
+		Records:               records,
+		Typecast:              typecast,
+		ReturnFieldsByFieldID: true,
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var data struct {
+		Records []types.Record `json:"records"`
+	}
+	if err := a.doRequest(ctx, method, a.recordsURL(tableID), body, &data); err != nil {
+		return nil, err
+	}
+	return data.Records, nil
+}
+
+// DeleteRecords deletes records by ID and returns the IDs Airtable reports
+// as deleted.
+func (a *Airtable) DeleteRecords(ctx context.Context, tableID string, recordIDs []string) ([]string, error) {
+	if len(recordIDs) > MaxRecordsPerRequest {
+		return nil, fmt.Errorf("airtable accepts at most %d records per request, got %d", MaxRecordsPerRequest, len(recordIDs))
+	}
+
+	q := url.Values{}
+	for _, id := range recordIDs {
+		q.Add("records[]", id)
+	}
+
+	var data struct {
+		Records []struct {
+			ID      string `json:"id"`
+			Deleted bool   `json:"deleted"`
+		} `json:"records"`
+	}
+	if err := a.doRequest(ctx, "DELETE", a.recordsURL(tableID)+"?"+q.Encode(), nil, &data); err != nil {
+		return nil, err
+	}
+
+	deleted := make([]string, 0, len(data.Records))
+	for _, r := range data.Records {
+		if r.Deleted {
+			deleted = append(deleted, r.ID)
+		}
+	}
+	return deleted, nil
+}
@@ -31,7 +31,7 @@ func (a *Airtable) refreshToken(ctx context.Context) error {
 	// Do NOT include client_id when using Basic Auth with client_secret
 
 	req, err := http.NewRequestWithContext(ctx, "POST",
-		"https://airtable.com/oauth2/v1/token",
+		a.TokenURL,
 		strings.NewReader(form.Encode()),
 	)
 	if err != nil {
@@ -42,7 +42,7 @@ func (a *Airtable) refreshToken(ctx context.Context) error {
 	req.Header.Set("Accept", "application/json")
 	req.SetBasicAuth(a.ClientID, a.ClientSecret)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := a.HTTPClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -5,13 +5,115 @@ import (
 	"context"
 	"dbpiper/database/models"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"math/rand/v2"
 	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// requestsPerSecond is the rate Airtable allows on each base.
+	requestsPerSecond = 5
+	maxAttempts       = 5
+)
+
+// The waits between attempts are variables so tests can shorten them.
+var (
+	// throttleBackoff is how long Airtable asks clients to wait after a 429.
+	throttleBackoff = 30 * time.Second
+	retryBaseDelay  = time.Second
+	retryMaxDelay   = 30 * time.Second
 )
 
+// httpClient is shared by clients that are not given their own, so
+// connections are reused.
+var httpClient = &http.Client{Timeout: time.Minute}
+
+// baseLimiter spaces out the requests of this process to one base and
+// holds them all back once Airtable throttled it.
+type baseLimiter struct {
+	limiter *rate.Limiter
+
+	mu    sync.Mutex
+	until time.Time
+}
+
+var (
+	limitersMu sync.Mutex
+	limiters   = make(map[string]*baseLimiter)
+)
+
+func limiterFor(baseID string) *baseLimiter {
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+	l, ok := limiters[baseID]
+	if !ok {
+		l = &baseLimiter{limiter: rate.NewLimiter(requestsPerSecond, 1)}
+		limiters[baseID] = l
+	}
+	return l
+}
+
+// wait blocks until a request to the base may be sent or ctx is done.
+func (l *baseLimiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	until := l.until
+	l.mu.Unlock()
+	if d := time.Until(until); d > 0 {
+		throttleStats.waited.Add(1)
+		if err := sleep(ctx, d); err != nil {
+			return err
+		}
+	}
+	return l.limiter.Wait(ctx)
+}
+
+// backOff holds back every request to the base for d.
+func (l *baseLimiter) backOff(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until := time.Now().Add(d); until.After(l.until) {
+		l.until = until
+	}
+}
+
+// throttleStats counts throttling since the process started.
+var throttleStats struct {
+	throttled atomic.Int64
+	retried   atomic.Int64
+	waited    atomic.Int64
+}
+
+// ThrottleStats is what throttling requests to Airtable cost so far.
+type ThrottleStats struct {
+	// responses with status 429
+	Throttled int64 `json:"throttled"`
+	// requests sent again after a 429, a 5xx or a network error
+	Retried int64 `json:"retried"`
+	// requests held back because their base was throttled
+	Waited int64 `json:"waited"`
+}
+
+func GetThrottleStats() ThrottleStats {
+	return ThrottleStats{
+		Throttled: throttleStats.throttled.Load(),
+		Retried:   throttleStats.retried.Load(),
+		Waited:    throttleStats.waited.Load(),
+	}
+}
+
+// doRequest sends a request to the base of the connection at the rate
+// Airtable allows. A 429 holds back the whole base for 30 seconds and is
+// retried. Server errors and network errors are retried with jittered
+// backoff too, except for POST, which could create records twice.
 func (a *Airtable) doRequest(ctx context.Context, method, url string, body []byte, response any) error {
-	client := &http.Client{}
 	if a.DB == nil {
 		return fmt.Errorf("database required for this call")
 	}
@@ -19,20 +121,66 @@ func (a *Airtable) doRequest(ctx context.Context, method, url string, body []byt
 		return fmt.Errorf("airtable required for this call")
 	}
 
+	limiter := limiterFor(a.Conn.BaseID)
+	for attempt := 1; ; attempt++ {
+		if err := limiter.wait(ctx); err != nil {
+			return err
+		}
+
+		retry, err := a.send(ctx, method, url, body, response)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		var delay time.Duration
+		switch {
+		case retry == retryThrottled:
+			throttleStats.throttled.Add(1)
+			limiter.backOff(throttleBackoff)
+			log.Printf("airtable: base %s throttled, backing off %s", a.Conn.BaseID, throttleBackoff)
+		case retry == retryFailed && method != http.MethodPost:
+			delay = retryDelay(attempt)
+		default:
+			return err
+		}
+		if attempt >= maxAttempts {
+			return err
+		}
+		throttleStats.retried.Add(1)
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// retryKind tells whether a failed request may be sent again.
+type retryKind int
+
+const (
+	retryNever retryKind = iota
+	retryThrottled
+	retryFailed
+)
+
+// send makes one attempt at a request.
+func (a *Airtable) send(ctx context.Context, method, url string, body []byte, response any) (retryKind, error) {
 	if a.Conn.ConnectionType == models.OAuth && a.tokenExpired() {
 		if err := a.refreshToken(ctx); err != nil {
-			return err
+			return retryNever, err
 		}
 	}
 
 	var reqBody io.Reader
 	if body != nil {
-		reqBody = bytes.NewBuffer(body)
+		reqBody = bytes.NewReader(body)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
 	if err != nil {
-		return err
+		return retryNever, err
 	}
 
 	var access string
@@ -44,16 +192,103 @@ func (a *Airtable) doRequest(ctx context.Context, method, url string, body []byt
 	}
 	req.Header.Add("Authorization", "Bearer "+access)
 	req.Header.Add("Content-Type", "application/json")
-	res, err := client.Do(req)
+	if a.Requests != nil {
+		a.Requests.Add(1)
+	}
+	res, err := a.HTTPClient.Do(req)
 	if err != nil {
-		return err
+		return retryFailed, err
 	}
 	defer res.Body.Close()
 
-	if res.StatusCode != 200 {
+	if res.StatusCode < 200 || res.StatusCode > 299 {
 		b, _ := io.ReadAll(res.Body)
-		return fmt.Errorf("airtable error: %s", string(b))
+		switch {
+		case res.StatusCode == http.StatusTooManyRequests:
+			return retryThrottled, newError(res.StatusCode, b)
+		case res.StatusCode >= 500:
+			return retryFailed, newError(res.StatusCode, b)
+		}
+		return retryNever, newError(res.StatusCode, b)
+	}
+	if response == nil || res.StatusCode == http.StatusNoContent {
+		return retryNever, nil
+	}
+
+	return retryNever, json.NewDecoder(res.Body).Decode(&response)
+}
+
+// retryDelay doubles with every attempt up to retryMaxDelay, half of it
+// random so requests that failed together do not retry together.
+func retryDelay(attempt int) time.Duration {
+	delay := min(retryBaseDelay<<(attempt-1), retryMaxDelay)
+	half := delay / 2
+	return half + rand.N(half)
+}
+
+// sleep waits for d unless ctx is done first.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// offsetExpired is the error type returned when a list offset is no longer
+// valid.
+const offsetExpired = "LIST_RECORDS_ITERATOR_NOT_AVAILABLE"
+
+// rowDoesNotExist is the error type returned when a write names a record
+// that was deleted.
+const rowDoesNotExist = "ROW_DOES_NOT_EXIST"
+
+// Error is a non-200 response from the Airtable API.
+type Error struct {
+	StatusCode int
+	Type       string
+	Body       string
+}
+
+func (e *Error) Error() string {
+	return "airtable error: " + e.Body
+}
+
+// newError reads the error type from a response body, which is either
+// {"error": "TYPE"} or {"error": {"type": "TYPE", "message": "..."}}.
+func newError(status int, body []byte) *Error {
+	e := &Error{StatusCode: status, Body: string(body)}
+	var payload struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if json.Unmarshal(body, &payload) != nil {
+		return e
+	}
+	var detail struct {
+		Type string `json:"type"`
 	}
+	if json.Unmarshal(payload.Error, &e.Type) != nil && json.Unmarshal(payload.Error, &detail) == nil {
+		e.Type = detail.Type
+	}
+	return e
+}
+
+// IsOffsetExpired reports whether a ListRecords call failed because the
+// offset of a previous page expired.
+func IsOffsetExpired(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Type == offsetExpired
+}
 
-	return json.NewDecoder(res.Body).Decode(&response)
+// IsNotFound reports whether a call failed because a record it names does
+// not exist.
+func IsNotFound(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && (e.StatusCode == http.StatusNotFound || e.Type == rowDoesNotExist)
 }
@@ -0,0 +1,159 @@
+package airtable
+
+import (
+	"context"
+	"database/sql"
+	"dbpiper/database"
+	"dbpiper/database/models"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// shortenDelays makes retries wait milliseconds instead of seconds.
+func shortenDelays(t *testing.T) {
+	backoff, base, maxDelay := throttleBackoff, retryBaseDelay, retryMaxDelay
+	throttleBackoff, retryBaseDelay, retryMaxDelay = 50*time.Millisecond, time.Millisecond, 10*time.Millisecond
+	t.Cleanup(func() {
+		throttleBackoff, retryBaseDelay, retryMaxDelay = backoff, base, maxDelay
+	})
+}
+
+// testClient talks to url with an API key, on a base of its own so tests
+// do not share a rate limiter.
+func testClient(t *testing.T, url string) *Airtable {
+	var db database.DB
+	conn := &models.AirtableConnection{
+		ConnectionType: models.APIKey,
+		APIKey:         sql.NullString{String: "key", Valid: true},
+		BaseID:         t.Name(),
+	}
+	return New(&db, conn, WithAPIURL(url)).(*Airtable)
+}
+
+func TestDoRequestRetries(t *testing.T) {
+	shortenDelays(t)
+
+	const (
+		ok          = http.StatusOK
+		throttled   = http.StatusTooManyRequests
+		serverError = http.StatusServiceUnavailable
+		invalid     = http.StatusUnprocessableEntity
+	)
+	tests := []struct {
+		name          string
+		method        string
+		statuses      []int // the last one repeats
+		wantRequests  int64
+		wantStatus    int // of the error returned, 0 for none
+		wantThrottled int64
+		minElapsed    time.Duration
+	}{
+		{"ok", http.MethodGet, []int{ok}, 1, 0, 0, 0},
+		{"server error then ok", http.MethodGet, []int{serverError, serverError, ok}, 3, 0, 0, 0},
+		{"server error gives up", http.MethodGet, []int{serverError}, maxAttempts, serverError, 0, 0},
+		{"server error on POST is not retried", http.MethodPost, []int{serverError, ok}, 1, serverError, 0, 0},
+		{"client error is not retried", http.MethodGet, []int{invalid, ok}, 1, invalid, 0, 0},
+		{"throttled then ok", http.MethodGet, []int{throttled, ok}, 2, 0, 1, 50 * time.Millisecond},
+		{"throttled POST is retried", http.MethodPost, []int{throttled, ok}, 2, 0, 1, 50 * time.Millisecond},
+		{"throttled gives up", http.MethodGet, []int{throttled}, maxAttempts, throttled, maxAttempts, 4 * 50 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int64
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(requests.Add(1))
+				status := tt.statuses[min(n, len(tt.statuses))-1]
+				w.WriteHeader(status)
+				if status == ok {
+					w.Write([]byte(`{"id":"recA"}`))
+				} else {
+					w.Write([]byte(`{"error":{"type":"FAILED"}}`))
+				}
+			}))
+			defer srv.Close()
+
+			a := testClient(t, srv.URL)
+			throttledBefore := GetThrottleStats().Throttled
+			start := time.Now()
+
+			var response struct {
+				ID string `json:"id"`
+			}
+			err := a.doRequest(context.Background(), tt.method, srv.URL+"/appA/tblA", []byte(`{}`), &response)
+
+			if got := requests.Load(); got != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", got, tt.wantRequests)
+			}
+			if tt.wantStatus == 0 {
+				if err != nil {
+					t.Fatalf("doRequest() error = %v", err)
+				}
+				if response.ID != "recA" {
+					t.Errorf("response id = %q, want recA", response.ID)
+				}
+			} else {
+				var e *Error
+				if !errors.As(err, &e) || e.StatusCode != tt.wantStatus {
+					t.Errorf("doRequest() error = %v, want status %d", err, tt.wantStatus)
+				}
+			}
+			if got := GetThrottleStats().Throttled - throttledBefore; got != tt.wantThrottled {
+				t.Errorf("throttled = %d, want %d", got, tt.wantThrottled)
+			}
+			if elapsed := time.Since(start); elapsed < tt.minElapsed {
+				t.Errorf("took %s, want at least %s", elapsed, tt.minElapsed)
+			}
+		})
+	}
+}
+
+// A 429 holds back every request to the base, not just the one that got it.
+func TestBackOffHoldsBackBase(t *testing.T) {
+	l := limiterFor(t.Name())
+	if limiterFor(t.Name()) != l {
+		t.Fatal("clients of one base got different limiters")
+	}
+
+	l.backOff(100 * time.Millisecond)
+	// a shorter backoff does not cut the longer one short
+	l.backOff(10 * time.Millisecond)
+
+	start := time.Now()
+	if err := l.wait(context.Background()); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("waited %s, want about 100ms", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	l.backOff(time.Minute)
+	if err := l.wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("wait with cancelled context: error = %v, want canceled", err)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{1, 500 * time.Millisecond, time.Second},
+		{2, time.Second, 2 * time.Second},
+		{4, 4 * time.Second, 8 * time.Second},
+		{6, 15 * time.Second, 30 * time.Second},
+		{20, 15 * time.Second, 30 * time.Second},
+	}
+	for _, tt := range tests {
+		for range 20 {
+			if got := retryDelay(tt.attempt); got < tt.min || got >= tt.max {
+				t.Fatalf("retryDelay(%d) = %s, want in [%s, %s)", tt.attempt, got, tt.min, tt.max)
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package airtable
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"dbpiper/types"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MACHeader carries the signature of a webhook ping.
+const MACHeader = "X-Airtable-Content-MAC"
+
+// Webhooks tell dbpiper that a base changed. Airtable pings WebhookURI,
+// and the changes themselves are read from the webhook's payloads. A
+// webhook expires 7 days after it was created or last refreshed.
+
+func (a *Airtable) webhooksURL() string {
+	return a.apiURLf(webhooksPath, a.Conn.BaseID)
+}
+
+func (a *Airtable) webhookURL(webhookID string) string {
+	return a.webhooksURL() + "/" + url.PathEscape(webhookID)
+}
+
+// CreateWebhook creates a webhook on the record data of one table of the
+// base; Airtable scopes a webhook to a single table or the whole base.
+func (a *Airtable) CreateWebhook(ctx context.Context, tableID string) (*types.Webhook, error) {
+	filters := map[string]any{
+		"dataTypes":         []string{"tableData"},
+		"recordChangeScope": tableID,
+	}
+	body, err := json.Marshal(map[string]any{
+		"notificationUrl": a.WebhookURI,
+		"specification": map[string]any{
+			"options": map[string]any{"filters": filters},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var webhook types.Webhook
+	if err := a.doRequest(ctx, "POST", a.webhooksURL(), body, &webhook); err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+// RefreshWebhook extends the life of a webhook and returns its new
+// expiration time.
+func (a *Airtable) RefreshWebhook(ctx context.Context, webhookID string) (time.Time, error) {
+	var data struct {
+		ExpirationTime time.Time `json:"expirationTime"`
+	}
+	if err := a.doRequest(ctx, "POST", a.webhookURL(webhookID)+"/refresh", nil, &data); err != nil {
+		return time.Time{}, err
+	}
+	return data.ExpirationTime, nil
+}
+
+// ListWebhookPayloads returns the payloads of a webhook from cursor on.
+// When MightHaveMore is set, the next page starts at the returned Cursor.
+func (a *Airtable) ListWebhookPayloads(ctx context.Context, webhookID string, cursor int64) (*types.WebhookPayloads, error) {
+	u := a.webhookURL(webhookID) + "/payloads?cursor=" + strconv.FormatInt(cursor, 10)
+	var page types.WebhookPayloads
+	if err := a.doRequest(ctx, "GET", u, nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+func (a *Airtable) DeleteWebhook(ctx context.Context, webhookID string) error {
+	return a.doRequest(ctx, "DELETE", a.webhookURL(webhookID), nil, nil)
+}
+
+// VerifyMAC reports whether mac, the MACHeader of a ping, is the HMAC-SHA256
+// of body under the webhook's secret.
+func VerifyMAC(secretBase64 string, body []byte, mac string) bool {
+	secret, err := base64.StdEncoding.DecodeString(secretBase64)
+	if err != nil || len(secret) == 0 {
+		return false
+	}
+	got, err := hex.DecodeString(strings.TrimPrefix(mac, "hmac-sha256="))
+	if err != nil {
+		return false
+	}
+	h := hmac.New(sha256.New, secret)
+	h.Write(body)
+	return hmac.Equal(got, h.Sum(nil))
+}
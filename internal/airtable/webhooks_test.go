@@ -0,0 +1,46 @@
+package airtable
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestVerifyMAC(t *testing.T) {
+	secret := []byte("webhook secret")
+	secretBase64 := base64.StdEncoding.EncodeToString(secret)
+	body := []byte(`{"base":{"id":"appA"},"webhook":{"id":"achW"},"timestamp":"2024-03-01T12:00:00.000Z"}`)
+
+	h := hmac.New(sha256.New, secret)
+	h.Write(body)
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	tests := []struct {
+		name   string
+		secret string
+		body   []byte
+		mac    string
+		want   bool
+	}{
+		{"valid", secretBase64, body, "hmac-sha256=" + sum, true},
+		{"valid without prefix", secretBase64, body, sum, true},
+		{"upper case hex", secretBase64, body, "hmac-sha256=" + strings.ToUpper(sum), true},
+		{"body changed", secretBase64, append([]byte(" "), body...), "hmac-sha256=" + sum, false},
+		{"other secret", base64.StdEncoding.EncodeToString([]byte("other")), body, "hmac-sha256=" + sum, false},
+		{"truncated mac", secretBase64, body, "hmac-sha256=" + sum[:32], false},
+		{"mac not hex", secretBase64, body, "hmac-sha256=not-hex", false},
+		{"no mac", secretBase64, body, "", false},
+		{"secret not base64", "not base64!", body, "hmac-sha256=" + sum, false},
+		{"no secret", "", body, "hmac-sha256=" + sum, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifyMAC(tt.secret, tt.body, tt.mac); got != tt.want {
+				t.Errorf("VerifyMAC() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
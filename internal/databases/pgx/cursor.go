@@ -0,0 +1,76 @@
+package pgx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Incremental reads for users who cannot install anything: a table is read
+// again from a high-water mark on a cursor column, a timestamp the
+// application sets on every write, or on xmin, the transaction that last
+// wrote the row. Marks are taken from the server before a read starts and
+// every read begins a little before its mark, so rows on the boundary and
+// rows of transactions still open at the mark are read again rather than
+// missed. Reading a row twice only rewrites it.
+
+// XminColumn is the cursor column that reads rows by transaction.
+const XminColumn = "xmin"
+
+// CursorLookback is how far before its mark a timestamp read begins. It
+// covers application clocks running behind the server and transactions that
+// commit after the mark was taken with an earlier timestamp.
+const CursorLookback = 5 * time.Minute
+
+// CheckCursorColumn returns an error unless column can be a cursor of table.
+func CheckCursorColumn(ctx context.Context, pool *pgxpool.Pool, tableName, column string) error {
+	if column == XminColumn {
+		return nil
+	}
+	types, err := ColumnTypes(ctx, pool, tableName)
+	if err != nil {
+		return err
+	}
+	typ, ok := types[column]
+	if !ok {
+		return fmt.Errorf("cursor column %s not found in table %s", column, tableName)
+	}
+	if !strings.HasPrefix(typ, "timestamp") {
+		return fmt.Errorf("cursor column %s of table %s is %s, not a timestamp", column, tableName, typ)
+	}
+	return nil
+}
+
+// CursorMark returns the high-water mark of column as of now. For xmin it
+// is the oldest transaction still running, truncated to the 32 bits xmin
+// holds, so transactions that commit later are never behind it.
+func CursorMark(ctx context.Context, pool *pgxpool.Pool, column string) (string, error) {
+	query := `SELECT now()::text`
+	if column == XminColumn {
+		query = `SELECT (txid_snapshot_xmin(txid_current_snapshot()) % 4294967296)::text`
+	}
+	var mark string
+	if err := pool.QueryRow(ctx, query).Scan(&mark); err != nil {
+		return "", err
+	}
+	if mark == "" {
+		return "", errors.New("empty cursor mark")
+	}
+	return mark, nil
+}
+
+// ChangedSince is a condition that keeps the rows written since the mark
+// given as text parameter $param. xmin is compared by age, which survives
+// transaction ID wraparound; frozen rows are the oldest of all.
+func ChangedSince(column string, param int) string {
+	if column == XminColumn {
+		return fmt.Sprintf("age(xmin) <= age($%d::text::xid)", param)
+	}
+	return fmt.Sprintf("%s >= $%d::text::timestamptz - interval '%d seconds'",
+		pgx.Identifier{column}.Sanitize(), param, int(CursorLookback.Seconds()))
+}
@@ -0,0 +1,152 @@
+package pgx
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// NotifyChannel is the channel dbpiper listens on. The payload of a
+// notification says who to wake; an empty payload wakes everyone listening
+// on that database, which is what a user provided trigger can send:
+//
+//	PERFORM pg_notify('dbpiper', '');
+const NotifyChannel = "dbpiper"
+
+const (
+	listenRetryMin = time.Second
+	listenRetryMax = time.Minute
+)
+
+// Listener keeps one LISTEN connection open per database connection that
+// has subscribers, and wakes them when a notification arrives. Connections
+// that drop are opened again with backoff.
+type Listener struct {
+	mu    sync.Mutex
+	conns map[string]*listenConn
+}
+
+type listenConn struct {
+	cancel context.CancelFunc
+	subs   map[*Subscription]bool
+}
+
+// Subscription is woken through C. Wakeups are coalesced: C holds at most
+// one pending wakeup.
+type Subscription struct {
+	C <-chan struct{}
+
+	c       chan struct{}
+	payload string
+	connID  string
+	l       *Listener
+}
+
+func NewListener() *Listener {
+	return &Listener{conns: make(map[string]*listenConn)}
+}
+
+// Subscribe wakes the returned subscription on every notification on the
+// database connID whose payload is payload or empty. It is also woken each
+// time the connection is (re)established, since notifications sent while it
+// was down are lost. The first subscriber of a database opens its
+// connection; Close on the last one closes it.
+func (l *Listener) Subscribe(connID, dsn, payload string) *Subscription {
+	c := make(chan struct{}, 1)
+	s := &Subscription{C: c, c: c, payload: payload, connID: connID, l: l}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lc, ok := l.conns[connID]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		lc = &listenConn{cancel: cancel, subs: make(map[*Subscription]bool)}
+		l.conns[connID] = lc
+		go l.run(ctx, connID, dsn)
+	}
+	lc.subs[s] = true
+	return s
+}
+
+func (s *Subscription) Close() {
+	s.l.mu.Lock()
+	defer s.l.mu.Unlock()
+	lc, ok := s.l.conns[s.connID]
+	if !ok {
+		return
+	}
+	delete(lc.subs, s)
+	if len(lc.subs) == 0 {
+		lc.cancel()
+		delete(s.l.conns, s.connID)
+	}
+}
+
+func (s *Subscription) wake() {
+	select {
+	case s.c <- struct{}{}:
+	default:
+	}
+}
+
+// notify wakes the subscribers of connID that payload is meant for.
+func (l *Listener) notify(connID, payload string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lc, ok := l.conns[connID]
+	if !ok {
+		return
+	}
+	for s := range lc.subs {
+		if payload == "" || s.payload == payload {
+			s.wake()
+		}
+	}
+}
+
+func (l *Listener) run(ctx context.Context, connID, dsn string) {
+	retry := listenRetryMin
+	for {
+		started := time.Now()
+		err := l.listen(ctx, connID, dsn)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > listenRetryMax {
+			retry = listenRetryMin
+		}
+		log.Printf("listener: database connection %s: %v; reconnecting in %s", connID, err, retry)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retry):
+		}
+		retry = min(retry*2, listenRetryMax)
+	}
+}
+
+// listen holds one connection until it fails or ctx is cancelled.
+func (l *Listener) listen(ctx context.Context, connID, dsn string) error {
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{NotifyChannel}.Sanitize()); err != nil {
+		return err
+	}
+	l.notify(connID, "")
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		l.notify(connID, n.Payload)
+	}
+}
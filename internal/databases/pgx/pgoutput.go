@@ -0,0 +1,284 @@
+package pgx
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Decoding of the pgoutput logical replication protocol, version 1. See
+// https://www.postgresql.org/docs/current/protocol-logicalrep-message-formats.html.
+
+// RelationColumn is one column of a replicated table.
+type RelationColumn struct {
+	Name   string
+	Key    bool // part of the replica identity
+	TypeID uint32
+}
+
+// Relation describes a replicated table. The server sends it before the
+// first change to the table in a session and again when it changes.
+type Relation struct {
+	ID        uint32
+	Namespace string
+	Name      string
+	Columns   []RelationColumn
+}
+
+// TupleValue is one column of a row. Unchanged TOAST values are not sent;
+// Unchanged is set for them instead.
+type TupleValue struct {
+	Null      bool
+	Unchanged bool
+	Text      string
+}
+
+type ChangeKind byte
+
+const (
+	Insert ChangeKind = 'I'
+	Update ChangeKind = 'U'
+	Delete ChangeKind = 'D'
+)
+
+// Change is a row inserted, updated or deleted in a transaction. Old holds
+// the replica identity (or the whole old row) when the server sent it; New
+// is empty for deletes.
+type Change struct {
+	Kind     ChangeKind
+	Relation *Relation
+	Old      []TupleValue
+	New      []TupleValue
+}
+
+// Keys returns the replica identity values of the rows a change touches, as
+// text: the old and the new key of an update that changed its key, the one
+// key otherwise.
+func (c *Change) Keys() [][]string {
+	var keys [][]string
+	for _, tuple := range [][]TupleValue{c.Old, c.New} {
+		if key, ok := c.Relation.keyOf(tuple); ok {
+			if len(keys) == 0 || !slices.Equal(keys[0], key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+func (r *Relation) keyOf(tuple []TupleValue) ([]string, bool) {
+	if tuple == nil {
+		return nil, false
+	}
+	var key []string
+	for i, col := range r.Columns {
+		if !col.Key {
+			continue
+		}
+		if i >= len(tuple) || tuple[i].Null || tuple[i].Unchanged {
+			return nil, false
+		}
+		key = append(key, tuple[i].Text)
+	}
+	return key, len(key) > 0
+}
+
+// Begin starts a transaction; Commit ends it. Both carry the LSN that can be
+// confirmed once the transaction has been handled.
+type Begin struct {
+	FinalLSN LSN
+}
+
+type Commit struct {
+	CommitLSN LSN
+	EndLSN    LSN
+}
+
+// Truncate empties the given relations.
+type Truncate struct {
+	Relations []*Relation
+}
+
+// Decoder turns pgoutput messages into the values above, keeping the
+// relations seen so far.
+type Decoder struct {
+	relations map[uint32]*Relation
+}
+
+func NewDecoder() *Decoder {
+	return &Decoder{relations: make(map[uint32]*Relation)}
+}
+
+var errShortMessage = errors.New("pgoutput: short message")
+
+// Decode returns *Begin, *Commit, *Relation, *Change, *Truncate, or nil for
+// messages that carry nothing a sync needs (origin, type).
+func (d *Decoder) Decode(data []byte) (any, error) {
+	if len(data) == 0 {
+		return nil, errShortMessage
+	}
+	r := &reader{buf: data[1:]}
+
+	switch data[0] {
+	case 'B':
+		msg := &Begin{FinalLSN: LSN(r.uint64())}
+		return msg, r.err
+	case 'C':
+		r.byte() // flags
+		msg := &Commit{CommitLSN: LSN(r.uint64()), EndLSN: LSN(r.uint64())}
+		return msg, r.err
+	case 'R':
+		rel := &Relation{ID: r.uint32(), Namespace: r.string(), Name: r.string()}
+		r.byte() // replica identity setting
+		n := int(r.uint16())
+		for range n {
+			flags := r.byte()
+			col := RelationColumn{Name: r.string(), Key: flags&1 != 0, TypeID: r.uint32()}
+			r.uint32() // type modifier
+			rel.Columns = append(rel.Columns, col)
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		d.relations[rel.ID] = rel
+		return rel, nil
+	case 'I':
+		rel, err := d.relation(r.uint32())
+		if err != nil {
+			return nil, err
+		}
+		if r.byte() != 'N' {
+			return nil, errors.New("pgoutput: insert without new tuple")
+		}
+		msg := &Change{Kind: Insert, Relation: rel, New: r.tuple()}
+		return msg, r.err
+	case 'U':
+		rel, err := d.relation(r.uint32())
+		if err != nil {
+			return nil, err
+		}
+		msg := &Change{Kind: Update, Relation: rel}
+		kind := r.byte()
+		if kind == 'K' || kind == 'O' {
+			msg.Old = r.tuple()
+			kind = r.byte()
+		}
+		if kind != 'N' {
+			return nil, errors.New("pgoutput: update without new tuple")
+		}
+		msg.New = r.tuple()
+		return msg, r.err
+	case 'D':
+		rel, err := d.relation(r.uint32())
+		if err != nil {
+			return nil, err
+		}
+		r.byte() // K or O
+		msg := &Change{Kind: Delete, Relation: rel, Old: r.tuple()}
+		return msg, r.err
+	case 'T':
+		n := int(r.uint32())
+		r.byte() // options
+		msg := &Truncate{}
+		for range n {
+			rel, err := d.relation(r.uint32())
+			if err != nil {
+				return nil, err
+			}
+			msg.Relations = append(msg.Relations, rel)
+		}
+		return msg, r.err
+	case 'O', 'Y', 'M':
+		return nil, nil
+	}
+	return nil, fmt.Errorf("pgoutput: unknown message %q", data[0])
+}
+
+func (d *Decoder) relation(id uint32) (*Relation, error) {
+	rel, ok := d.relations[id]
+	if !ok {
+		return nil, fmt.Errorf("pgoutput: change for unknown relation %d", id)
+	}
+	return rel, nil
+}
+
+// reader reads big endian values, remembering the first error.
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.buf) < n {
+		r.err = errShortMessage
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *reader) byte() byte {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) uint16() uint16 {
+	if b := r.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *reader) uint32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *reader) uint64() uint64 {
+	if b := r.next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+// string reads a NUL terminated string.
+func (r *reader) string() string {
+	if r.err != nil {
+		return ""
+	}
+	for i, c := range r.buf {
+		if c == 0 {
+			s := string(r.buf[:i])
+			r.buf = r.buf[i+1:]
+			return s
+		}
+	}
+	r.err = errShortMessage
+	return ""
+}
+
+func (r *reader) tuple() []TupleValue {
+	n := int(r.uint16())
+	values := make([]TupleValue, n)
+	for i := range values {
+		switch r.byte() {
+		case 'n':
+			values[i].Null = true
+		case 'u':
+			values[i].Unchanged = true
+		case 't', 'b':
+			size := int(r.uint32())
+			values[i].Text = string(r.next(size))
+		}
+	}
+	return values
+}
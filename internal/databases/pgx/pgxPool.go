@@ -8,6 +8,10 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// ApplicationName names dbpiper's sessions, so the changes it writes can be
+// told apart from everyone else's.
+const ApplicationName = "dbpiper"
+
 type PoolManager struct {
 	mu   sync.Mutex
 	pool map[string]*pgxpool.Pool
@@ -46,6 +50,7 @@ func (m *PoolManager) GetPool(ctx context.Context, connID string, dsn string) (*
 	cfg.MinConns = 1
 	cfg.MaxConnLifetime = time.Minute * 30
 	cfg.MaxConnIdleTime = time.Minute * 10
+	cfg.ConnConfig.RuntimeParams["application_name"] = ApplicationName
 
 	pool, err := pgxpool.NewWithConfig(ctx, cfg)
 	if err != nil {
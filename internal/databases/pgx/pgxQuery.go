@@ -1,10 +1,13 @@
 package pgx
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 const AllTables = `
@@ -33,3 +36,243 @@ func SelectQuery(tableName string, fields []string) string {
 		strings.Join(quotedFields, ", "),
 		quotedTable)
 }
+
+// PrimaryKeyColumns lists the primary key columns of a table in key order,
+// with their full type so values can be cast back from text.
+const PrimaryKeyColumns = `
+    SELECT a.attname, format_type(a.atttypid, a.atttypmod)
+    FROM pg_index i
+    JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+    WHERE i.indrelid = $1::regclass AND i.indisprimary
+    ORDER BY array_position(i.indkey::int2[], a.attnum)
+  `
+
+var ErrNoPrimaryKey = errors.New("table has no primary key")
+
+type KeyColumn struct {
+	Name string
+	Type string
+}
+
+func PrimaryKey(ctx context.Context, pool *pgxpool.Pool, tableName string) ([]KeyColumn, error) {
+	rows, err := pool.Query(ctx, PrimaryKeyColumns, pgx.Identifier{tableName}.Sanitize())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var key []KeyColumn
+	for rows.Next() {
+		var col KeyColumn
+		if err := rows.Scan(&col.Name, &col.Type); err != nil {
+			return nil, err
+		}
+		key = append(key, col)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(key) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoPrimaryKey, tableName)
+	}
+	return key, nil
+}
+
+// KeysetQuery selects the key columns (as text) followed by fields, ordered
+// by key. When after is true the query only returns rows past the key given
+// as text parameters $1..$n.
+func KeysetQuery(tableName string, fields []string, key []KeyColumn, after bool) string {
+	return KeysetQueryWhere(tableName, fields, key, after, "")
+}
+
+// KeysetQueryWhere is KeysetQuery restricted to rows matching where, whose
+// parameters follow the key parameters.
+func KeysetQueryWhere(tableName string, fields []string, key []KeyColumn, after bool, where string) string {
+	quotedKey := make([]string, len(key))
+	selected := make([]string, 0, len(key)+len(fields))
+	for i, k := range key {
+		quotedKey[i] = pgx.Identifier{k.Name}.Sanitize()
+		selected = append(selected, quotedKey[i]+"::text")
+	}
+	for _, field := range fields {
+		selected = append(selected, pgx.Identifier{field}.Sanitize())
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s",
+		strings.Join(selected, ", "),
+		pgx.Identifier{tableName}.Sanitize())
+
+	var conditions []string
+	if after {
+		params := make([]string, len(key))
+		for i, k := range key {
+			params[i] = fmt.Sprintf("$%d::text::%s", i+1, k.Type)
+		}
+		conditions = append(conditions, fmt.Sprintf("(%s) > (%s)",
+			strings.Join(quotedKey, ", "),
+			strings.Join(params, ", ")))
+	}
+	if where != "" {
+		conditions = append(conditions, where)
+	}
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	return query + " ORDER BY " + strings.Join(quotedKey, ", ")
+}
+
+// TableColumnTypes lists every column of a table with its full type.
+const TableColumnTypes = `
+    SELECT a.attname, format_type(a.atttypid, a.atttypmod)
+    FROM pg_attribute a
+    WHERE a.attrelid = $1::regclass AND a.attnum > 0 AND NOT a.attisdropped
+    ORDER BY a.attnum
+  `
+
+func ColumnTypes(ctx context.Context, pool *pgxpool.Pool, tableName string) (map[string]string, error) {
+	rows, err := pool.Query(ctx, TableColumnTypes, pgx.Identifier{tableName}.Sanitize())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	types := make(map[string]string)
+	for rows.Next() {
+		var name, typ string
+		if err := rows.Scan(&name, &typ); err != nil {
+			return nil, err
+		}
+		types[name] = typ
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(types) == 0 {
+		return nil, fmt.Errorf("table %s not found", tableName)
+	}
+	return types, nil
+}
+
+// castParams returns "$n::text::type" placeholders so every value can be
+// passed as text and converted by Postgres itself.
+func castParams(fields []string, columnTypes map[string]string, offset int) []string {
+	params := make([]string, len(fields))
+	for i, field := range fields {
+		params[i] = fmt.Sprintf("$%d::text::%s", offset+i+1, columnTypes[field])
+	}
+	return params
+}
+
+func quoteAll(fields []string) []string {
+	quoted := make([]string, len(fields))
+	for i, field := range fields {
+		quoted[i] = pgx.Identifier{field}.Sanitize()
+	}
+	return quoted
+}
+
+// UpsertQuery inserts one row of text parameters. When conflict columns are
+// given, an existing row with the same values there is updated instead.
+func UpsertQuery(tableName string, fields []string, columnTypes map[string]string, conflict []string) string {
+	quoted := quoteAll(fields)
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		pgx.Identifier{tableName}.Sanitize(),
+		strings.Join(quoted, ", "),
+		strings.Join(castParams(fields, columnTypes, 0), ", "))
+
+	if len(conflict) == 0 {
+		return query
+	}
+
+	updates := make([]string, len(quoted))
+	for i, q := range quoted {
+		updates[i] = fmt.Sprintf("%s = EXCLUDED.%s", q, q)
+	}
+	return query + fmt.Sprintf(" ON CONFLICT (%s) DO UPDATE SET %s",
+		strings.Join(quoteAll(conflict), ", "),
+		strings.Join(updates, ", "))
+}
+
+// UpdateQuery updates the row whose key matches the text parameters that
+// follow the field parameters.
+func UpdateQuery(tableName string, fields []string, columnTypes map[string]string, key []KeyColumn) string {
+	quoted := quoteAll(fields)
+	params := castParams(fields, columnTypes, 0)
+	sets := make([]string, len(quoted))
+	for i, q := range quoted {
+		sets[i] = fmt.Sprintf("%s = %s", q, params[i])
+	}
+	return fmt.Sprintf("UPDATE %s SET %s WHERE %s",
+		pgx.Identifier{tableName}.Sanitize(),
+		strings.Join(sets, ", "),
+		keyCondition(key, len(fields)))
+}
+
+// DeleteQuery deletes the row whose key matches the text parameters.
+func DeleteQuery(tableName string, key []KeyColumn) string {
+	return fmt.Sprintf("DELETE FROM %s WHERE %s",
+		pgx.Identifier{tableName}.Sanitize(),
+		keyCondition(key, 0))
+}
+
+// SoftDeleteQuery stamps column with the current time on the row whose key
+// matches the text parameters, unless it is already set.
+func SoftDeleteQuery(tableName, column string, key []KeyColumn) string {
+	quoted := pgx.Identifier{column}.Sanitize()
+	return fmt.Sprintf("UPDATE %s SET %s = now() WHERE %s AND %s IS NULL",
+		pgx.Identifier{tableName}.Sanitize(),
+		quoted,
+		keyCondition(key, 0),
+		quoted)
+}
+
+// keyCondition matches key columns against text parameters starting after
+// offset.
+func keyCondition(key []KeyColumn, offset int) string {
+	columns := make([]string, len(key))
+	params := make([]string, len(key))
+	for i, k := range key {
+		columns[i] = pgx.Identifier{k.Name}.Sanitize()
+		params[i] = fmt.Sprintf("$%d::text::%s", offset+i+1, k.Type)
+	}
+	return fmt.Sprintf("(%s) = (%s)", strings.Join(columns, ", "), strings.Join(params, ", "))
+}
+
+// Returning returns the key columns of the affected row as text, followed by
+// fields.
+func Returning(key []KeyColumn, fields []string) string {
+	columns := make([]string, len(key), len(key)+len(fields))
+	for i, k := range key {
+		columns[i] = pgx.Identifier{k.Name}.Sanitize() + "::text"
+	}
+	columns = append(columns, quoteAll(fields)...)
+	return " RETURNING " + strings.Join(columns, ", ")
+}
+
+// SelectByKeysQuery selects the key columns (as text) followed by fields for
+// the rows matching n keys, each given as consecutive text parameters.
+func SelectByKeysQuery(tableName string, fields []string, key []KeyColumn, n int) string {
+	selected := make([]string, 0, len(key)+len(fields))
+	columns := make([]string, len(key))
+	for i, k := range key {
+		columns[i] = pgx.Identifier{k.Name}.Sanitize()
+		selected = append(selected, columns[i]+"::text")
+	}
+	selected = append(selected, quoteAll(fields)...)
+
+	tuples := make([]string, n)
+	for i := range tuples {
+		params := make([]string, len(key))
+		for j, k := range key {
+			params[j] = fmt.Sprintf("$%d::text::%s", i*len(key)+j+1, k.Type)
+		}
+		tuples[i] = "(" + strings.Join(params, ", ") + ")"
+	}
+
+	return fmt.Sprintf("SELECT %s FROM %s WHERE (%s) IN (%s)",
+		strings.Join(selected, ", "),
+		pgx.Identifier{tableName}.Sanitize(),
+		strings.Join(columns, ", "),
+		strings.Join(tuples, ", "))
+}
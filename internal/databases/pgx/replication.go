@@ -0,0 +1,220 @@
+package pgx
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// LSN is a position in the write-ahead log.
+type LSN uint64
+
+func (l LSN) String() string {
+	return fmt.Sprintf("%X/%X", uint32(l>>32), uint32(l))
+}
+
+// ParseLSN reads the X/X form Postgres prints LSNs in.
+func ParseLSN(s string) (LSN, error) {
+	var hi, lo uint32
+	if _, err := fmt.Sscanf(s, "%X/%X", &hi, &lo); err != nil {
+		return 0, fmt.Errorf("invalid lsn %q: %w", s, err)
+	}
+	return LSN(uint64(hi)<<32 | uint64(lo)), nil
+}
+
+// Postgres timestamps in the replication protocol count microseconds from
+// 2000-01-01.
+var pgEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// ReplicationConnect opens a logical replication connection to the database
+// of dsn.
+func ReplicationConnect(ctx context.Context, dsn string) (*pgconn.PgConn, error) {
+	config, err := pgconn.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+	config.RuntimeParams["replication"] = "database"
+	config.RuntimeParams["application_name"] = ApplicationName
+	return pgconn.ConnectConfig(ctx, config)
+}
+
+// EnsurePublication creates a publication for tables, or points an existing
+// one at exactly those tables.
+func EnsurePublication(ctx context.Context, pool *pgxpool.Pool, name string, tables []string) error {
+	var exists bool
+	if err := pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM pg_publication WHERE pubname = $1)`, name).Scan(&exists); err != nil {
+		return err
+	}
+
+	verb := "CREATE PUBLICATION %s FOR TABLE %s"
+	if exists {
+		verb = "ALTER PUBLICATION %s SET TABLE %s"
+	}
+	_, err := pool.Exec(ctx, fmt.Sprintf(verb,
+		pgx.Identifier{name}.Sanitize(),
+		strings.Join(quoteAll(tables), ", ")))
+	return err
+}
+
+// EnsureSlot creates a pgoutput logical replication slot unless it exists.
+func EnsureSlot(ctx context.Context, pool *pgxpool.Pool, slot string) error {
+	var exists bool
+	if err := pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM pg_replication_slots WHERE slot_name = $1)`, slot).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	_, err := pool.Exec(ctx, `SELECT pg_create_logical_replication_slot($1, 'pgoutput')`, slot)
+	return err
+}
+
+// DropSlot removes a replication slot, ending the stream that uses it. A
+// slot that is already gone is fine.
+func DropSlot(ctx context.Context, pool *pgxpool.Pool, slot string) error {
+	if _, err := pool.Exec(ctx, `
+        SELECT pg_terminate_backend(active_pid)
+        FROM pg_replication_slots
+        WHERE slot_name = $1 AND active`, slot); err != nil {
+		return err
+	}
+	_, err := pool.Exec(ctx, `
+        SELECT pg_drop_replication_slot(slot_name)
+        FROM pg_replication_slots
+        WHERE slot_name = $1`, slot)
+	return err
+}
+
+func DropPublication(ctx context.Context, pool *pgxpool.Pool, publication string) error {
+	_, err := pool.Exec(ctx, "DROP PUBLICATION IF EXISTS "+pgx.Identifier{publication}.Sanitize())
+	return err
+}
+
+// SlotStatus is how far a replication slot lags behind the server.
+type SlotStatus struct {
+	Active       bool
+	CurrentLSN   string
+	ConfirmedLSN string
+	LagBytes     int64
+}
+
+// ErrNoSlot is returned when a replication slot does not exist.
+var ErrNoSlot = errors.New("replication slot does not exist")
+
+const slotStatusQuery = `
+    SELECT active,
+           pg_current_wal_lsn()::text,
+           COALESCE(confirmed_flush_lsn::text, ''),
+           COALESCE(pg_wal_lsn_diff(pg_current_wal_lsn(), confirmed_flush_lsn), 0)::bigint
+    FROM pg_replication_slots
+    WHERE slot_name = $1
+    `
+
+func GetSlotStatus(ctx context.Context, pool *pgxpool.Pool, slot string) (*SlotStatus, error) {
+	var s SlotStatus
+	err := pool.QueryRow(ctx, slotStatusQuery, slot).Scan(&s.Active, &s.CurrentLSN, &s.ConfirmedLSN, &s.LagBytes)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNoSlot
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// StartReplication streams the changes of publication from slot, starting
+// at the slot's confirmed position when start is 0. Messages are then read
+// with ReceiveMessage until the connection is closed.
+func StartReplication(ctx context.Context, conn *pgconn.PgConn, slot, publication string, start LSN) error {
+	query := fmt.Sprintf("START_REPLICATION SLOT %s LOGICAL %s (proto_version '1', publication_names %s)",
+		pgx.Identifier{slot}.Sanitize(), start, quoteLiteral(publication))
+
+	conn.Frontend().Send(&pgproto3.Query{String: query})
+	if err := conn.Frontend().Flush(); err != nil {
+		return err
+	}
+
+	for {
+		msg, err := conn.ReceiveMessage(ctx)
+		if err != nil {
+			return err
+		}
+		switch msg := msg.(type) {
+		case *pgproto3.CopyBothResponse:
+			return nil
+		case *pgproto3.ErrorResponse:
+			return pgconn.ErrorResponseToPgError(msg)
+		case *pgproto3.NoticeResponse:
+		default:
+			return fmt.Errorf("unexpected message %T starting replication", msg)
+		}
+	}
+}
+
+// SendStandbyStatus tells the server that everything up to lsn has been
+// processed, which lets it release the WAL before it.
+func SendStandbyStatus(ctx context.Context, conn *pgconn.PgConn, lsn LSN) error {
+	data := make([]byte, 0, 34)
+	data = append(data, 'r')
+	data = binary.BigEndian.AppendUint64(data, uint64(lsn)) // written
+	data = binary.BigEndian.AppendUint64(data, uint64(lsn)) // flushed
+	data = binary.BigEndian.AppendUint64(data, uint64(lsn)) // applied
+	data = binary.BigEndian.AppendUint64(data, uint64(time.Since(pgEpoch).Microseconds()))
+	data = append(data, 0)
+
+	conn.Frontend().Send(&pgproto3.CopyData{Data: data})
+	return conn.Frontend().Flush()
+}
+
+// ReplicationMessage is one CopyData message of a replication stream:
+// either WAL data or a keepalive.
+type ReplicationMessage struct {
+	// WALStart is where Data starts; set for WAL data only
+	WALStart LSN
+	// WALEnd is the end of the WAL on the server
+	WALEnd LSN
+	Data   []byte
+	// ReplyRequested is set on keepalives that want a status update now
+	Keepalive      bool
+	ReplyRequested bool
+}
+
+// ParseReplicationMessage decodes the payload of a CopyData message.
+func ParseReplicationMessage(data []byte) (*ReplicationMessage, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty replication message")
+	}
+	switch data[0] {
+	case 'w':
+		if len(data) < 25 {
+			return nil, errors.New("short WAL data message")
+		}
+		return &ReplicationMessage{
+			WALStart: LSN(binary.BigEndian.Uint64(data[1:])),
+			WALEnd:   LSN(binary.BigEndian.Uint64(data[9:])),
+			Data:     data[25:],
+		}, nil
+	case 'k':
+		if len(data) < 18 {
+			return nil, errors.New("short keepalive message")
+		}
+		return &ReplicationMessage{
+			WALEnd:         LSN(binary.BigEndian.Uint64(data[1:])),
+			Keepalive:      true,
+			ReplyRequested: data[17] == 1,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown replication message %q", data[0])
+}
+
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,189 @@
+package pgx
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Trigger based change capture, for databases where logical replication is
+// not available. Row level triggers on each captured table append the key
+// of every changed row to a changelog table, which is drained in order, and
+// notify NotifyChannel with the changelog name so the reader wakes at once.
+// Every object is named after prefix so it can be removed again.
+
+// Changelog names the objects installed for one capture.
+type Changelog struct {
+	Table    string
+	Function string
+	Trigger  string
+}
+
+func NewChangelog(prefix string) Changelog {
+	return Changelog{
+		Table:    prefix + "_changes",
+		Function: prefix + "_capture",
+		Trigger:  prefix + "_capture",
+	}
+}
+
+// captureFunction records the key of the changed row as a JSON array of
+// text values. The key columns are passed as trigger arguments. An update
+// that changes the key also records the old key, as a delete. Notifications
+// are delivered on commit, once per transaction. Changes dbpiper writes
+// itself are not recorded, so they do not echo back.
+const captureFunction = `
+CREATE OR REPLACE FUNCTION %[1]s() RETURNS trigger
+LANGUAGE plpgsql AS $$
+DECLARE
+    new_key jsonb := '[]';
+    old_key jsonb := '[]';
+    col text;
+    value text;
+BEGIN
+    IF current_setting('application_name') = %[5]s THEN
+        RETURN NULL;
+    END IF;
+
+    FOREACH col IN ARRAY TG_ARGV LOOP
+        IF TG_OP <> 'DELETE' THEN
+            EXECUTE format('SELECT ($1).%%I::text', col) USING NEW INTO value;
+            new_key := new_key || to_jsonb(value);
+        END IF;
+        IF TG_OP <> 'INSERT' THEN
+            EXECUTE format('SELECT ($1).%%I::text', col) USING OLD INTO value;
+            old_key := old_key || to_jsonb(value);
+        END IF;
+    END LOOP;
+
+    IF TG_OP = 'DELETE' OR (TG_OP = 'UPDATE' AND old_key <> new_key) THEN
+        INSERT INTO %[2]s (table_name, op, key) VALUES (TG_TABLE_NAME, 'D', old_key);
+    END IF;
+    IF TG_OP <> 'DELETE' THEN
+        INSERT INTO %[2]s (table_name, op, key) VALUES (TG_TABLE_NAME, left(TG_OP, 1), new_key);
+    END IF;
+    PERFORM pg_notify(%[3]s, %[4]s);
+    RETURN NULL;
+END
+$$`
+
+// InstallChangelog creates the changelog table and function, and a trigger
+// on each table, whose key columns are given by keys. Tables captured before
+// but no longer in tables lose their trigger. It runs in one transaction and
+// can be repeated.
+func InstallChangelog(ctx context.Context, pool *pgxpool.Pool, cl Changelog, keys map[string][]KeyColumn) error {
+	return pgx.BeginFunc(ctx, pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, fmt.Sprintf(`
+            CREATE TABLE IF NOT EXISTS %s (
+                id         bigserial PRIMARY KEY,
+                table_name text NOT NULL,
+                op         char(1) NOT NULL,
+                key        jsonb NOT NULL,
+                changed_at timestamptz NOT NULL DEFAULT now()
+            )`, pgx.Identifier{cl.Table}.Sanitize())); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, fmt.Sprintf(captureFunction,
+			pgx.Identifier{cl.Function}.Sanitize(),
+			pgx.Identifier{cl.Table}.Sanitize(),
+			quoteLiteral(NotifyChannel),
+			quoteLiteral(cl.Table),
+			quoteLiteral(ApplicationName))); err != nil {
+			return err
+		}
+
+		if err := dropTriggers(ctx, tx, cl.Trigger); err != nil {
+			return err
+		}
+		for table, key := range keys {
+			args := make([]string, len(key))
+			for i, k := range key {
+				args[i] = quoteLiteral(k.Name)
+			}
+			if _, err := tx.Exec(ctx, fmt.Sprintf(
+				"CREATE TRIGGER %s AFTER INSERT OR UPDATE OR DELETE ON %s FOR EACH ROW EXECUTE FUNCTION %s(%s)",
+				pgx.Identifier{cl.Trigger}.Sanitize(),
+				pgx.Identifier{table}.Sanitize(),
+				pgx.Identifier{cl.Function}.Sanitize(),
+				strings.Join(args, ", "))); err != nil {
+				return fmt.Errorf("trigger on %s: %w", table, err)
+			}
+		}
+		return nil
+	})
+}
+
+// UninstallChangelog removes every trigger, the function and the changelog
+// table, with whatever changes were still in it.
+func UninstallChangelog(ctx context.Context, pool *pgxpool.Pool, cl Changelog) error {
+	return pgx.BeginFunc(ctx, pool, func(tx pgx.Tx) error {
+		if err := dropTriggers(ctx, tx, cl.Trigger); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, fmt.Sprintf("DROP FUNCTION IF EXISTS %s()",
+			pgx.Identifier{cl.Function}.Sanitize())); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s",
+			pgx.Identifier{cl.Table}.Sanitize()))
+		return err
+	})
+}
+
+// dropTriggers drops the trigger called name from every table that has it.
+func dropTriggers(ctx context.Context, tx pgx.Tx, name string) error {
+	rows, err := tx.Query(ctx, `
+        SELECT c.relname
+        FROM pg_trigger t
+        JOIN pg_class c ON c.oid = t.tgrelid
+        WHERE t.tgname = $1 AND NOT t.tgisinternal`, name)
+	if err != nil {
+		return err
+	}
+	tables, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		if _, err := tx.Exec(ctx, fmt.Sprintf("DROP TRIGGER IF EXISTS %s ON %s",
+			pgx.Identifier{name}.Sanitize(),
+			pgx.Identifier{table}.Sanitize())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ChangelogEntry is one row of a changelog table.
+type ChangelogEntry struct {
+	ID    int64
+	Table string
+	Op    string // I, U or D
+	Key   []string
+}
+
+// ClaimChangelog locks and returns up to limit of the oldest entries,
+// skipping entries another consumer holds. They stay locked until tx ends.
+func ClaimChangelog(ctx context.Context, tx pgx.Tx, cl Changelog, limit int) ([]ChangelogEntry, error) {
+	rows, err := tx.Query(ctx, fmt.Sprintf(
+		"SELECT id, table_name, op, key FROM %s ORDER BY id LIMIT %d FOR UPDATE SKIP LOCKED",
+		pgx.Identifier{cl.Table}.Sanitize(), limit))
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (ChangelogEntry, error) {
+		var e ChangelogEntry
+		err := row.Scan(&e.ID, &e.Table, &e.Op, &e.Key)
+		return e, err
+	})
+}
+
+// PruneChangelog deletes delivered entries.
+func PruneChangelog(ctx context.Context, tx pgx.Tx, cl Changelog, ids []int64) error {
+	_, err := tx.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE id = ANY($1)",
+		pgx.Identifier{cl.Table}.Sanitize()), ids)
+	return err
+}
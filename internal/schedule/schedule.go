@@ -0,0 +1,206 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule tells when something runs next.
+type Schedule interface {
+	// Next returns the first run strictly after t, in t's location, or the
+	// zero time if there is none.
+	Next(t time.Time) time.Time
+}
+
+// Parse accepts "@every <duration>" (at least one minute), the
+// @hourly/@daily/@midnight/@weekly/@monthly/@yearly shorthands, and standard
+// five field cron expressions: minute hour day-of-month month day-of-week.
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if d, ok := strings.CutPrefix(spec, "@every "); ok {
+		interval, err := time.ParseDuration(strings.TrimSpace(d))
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval: %w", err)
+		}
+		if interval < time.Minute {
+			return nil, errors.New("interval must be at least one minute")
+		}
+		return every(interval), nil
+	}
+
+	switch spec {
+	case "@hourly":
+		spec = "0 * * * *"
+	case "@daily", "@midnight":
+		spec = "0 0 * * *"
+	case "@weekly":
+		spec = "0 0 * * 0"
+	case "@monthly":
+		spec = "0 0 1 * *"
+	case "@yearly", "@annually":
+		spec = "0 0 1 1 *"
+	}
+	return parseCron(spec)
+}
+
+type every time.Duration
+
+func (e every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e)).Truncate(time.Second)
+}
+
+// cron holds one bit per allowed value of each field.
+type cron struct {
+	minute, hour, dom, month, dow uint64
+	// day-of-month and day-of-week match as OR when both are restricted
+	domStar, dowStar bool
+}
+
+type bounds struct{ min, max int }
+
+var (
+	minutes = bounds{0, 59}
+	hours   = bounds{0, 23}
+	doms    = bounds{1, 31}
+	months  = bounds{1, 12}
+	dows    = bounds{0, 7} // 0 and 7 are both Sunday
+)
+
+func parseCron(spec string) (*cron, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression needs 5 fields, got %d", len(fields))
+	}
+
+	c := &cron{
+		domStar: fields[2] == "*" || fields[2] == "?",
+		dowStar: fields[4] == "*" || fields[4] == "?",
+	}
+	var err error
+	if c.minute, err = parseField(fields[0], minutes); err != nil {
+		return nil, fmt.Errorf("minute: %w", err)
+	}
+	if c.hour, err = parseField(fields[1], hours); err != nil {
+		return nil, fmt.Errorf("hour: %w", err)
+	}
+	if c.dom, err = parseField(fields[2], doms); err != nil {
+		return nil, fmt.Errorf("day of month: %w", err)
+	}
+	if c.month, err = parseField(fields[3], months); err != nil {
+		return nil, fmt.Errorf("month: %w", err)
+	}
+	if c.dow, err = parseField(fields[4], dows); err != nil {
+		return nil, fmt.Errorf("day of week: %w", err)
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	return c, nil
+}
+
+// parseField parses a comma separated list of *, n, a-b and any of those
+// with a /step.
+func parseField(field string, b bounds) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepStr)
+			}
+		}
+
+		lo, hi := b.min, b.max
+		switch {
+		case rng == "*" || rng == "?":
+		case strings.Contains(rng, "-"):
+			from, to, _ := strings.Cut(rng, "-")
+			var err error
+			if lo, err = parseValue(from, b); err != nil {
+				return 0, err
+			}
+			if hi, err = parseValue(to, b); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range %q", rng)
+			}
+		default:
+			v, err := parseValue(rng, b)
+			if err != nil {
+				return 0, err
+			}
+			lo = v
+			if !hasStep {
+				hi = v
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func parseValue(s string, b bounds) (int, error) {
+	v, err := strconv.Atoi(s)
+	if err != nil || v < b.min || v > b.max {
+		return 0, fmt.Errorf("value %q out of range %d-%d", s, b.min, b.max)
+	}
+	return v, nil
+}
+
+// Next walks forward field by field, resetting the smaller fields whenever a
+// larger one moves. A time that falls in a DST gap does not exist on that
+// day and is skipped. The zero time means the expression never matches.
+func (c *cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// give up after five years; only impossible dates (Feb 30) get here
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = forward(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location()))
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = forward(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location()))
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = forward(t, time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location()))
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// forward returns next, the start of a later month, day or hour than t's,
+// as a time after t. time.Date moves a time in a DST gap back by the length
+// of the gap, which can land on t's own hour again.
+func forward(t, next time.Time) time.Time {
+	for !next.After(t) {
+		next = next.Add(time.Hour)
+	}
+	return next
+}
+
+func (c *cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
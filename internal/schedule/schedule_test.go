@@ -0,0 +1,136 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{"* * * * *", false},
+		{"*/15 9-17 * * 1-5", false},
+		{"0 0 1,15 * *", false},
+		{"0 0 * * 7", false},
+		{"0 0 ? * ?", false},
+		{"@daily", false},
+		{"@every 5m", false},
+		{"@every 90s", false},
+		{"", true},
+		{"* * * *", true},
+		{"* * * * * *", true},
+		{"60 * * * *", true},
+		{"* 24 * * *", true},
+		{"* * 0 * *", true},
+		{"* * 32 * *", true},
+		{"* * * 13 *", true},
+		{"* * * * 8", true},
+		{"5-1 * * * *", true},
+		{"*/0 * * * *", true},
+		{"*/x * * * *", true},
+		{"a * * * *", true},
+		{"@every 30s", true},
+		{"@every soon", true},
+		{"@fortnightly", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			_, err := Parse(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse(%q) error = %v, want error %v", tt.spec, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+	// clocks in Santiago skip midnight when DST starts
+	santiago, err := time.LoadLocation("America/Santiago")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+	utc := func(s string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	inNewYork := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, newYork)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	inSantiago := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, santiago)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	tests := []struct {
+		name string
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"every minute", "* * * * *", utc("2024-03-01 12:00"), utc("2024-03-01 12:01")},
+		{"strictly after", "30 12 * * *", utc("2024-03-01 12:30"), utc("2024-03-02 12:30")},
+		{"seconds are dropped", "* * * * *", utc("2024-03-01 12:00").Add(59 * time.Second), utc("2024-03-01 12:01")},
+		{"hourly", "@hourly", utc("2024-03-01 12:10"), utc("2024-03-01 13:00")},
+		{"daily rolls over the month", "@daily", utc("2024-02-29 08:00"), utc("2024-03-01 00:00")},
+		{"yearly", "@yearly", utc("2024-03-01 00:00"), utc("2025-01-01 00:00")},
+		{"range with step", "*/20 9-17 * * *", utc("2024-03-01 17:41"), utc("2024-03-02 09:00")},
+		{"step on a single value", "5/20 * * * *", utc("2024-03-01 12:06"), utc("2024-03-01 12:25")},
+		{"step on a single value wraps", "5/20 * * * *", utc("2024-03-01 12:46"), utc("2024-03-01 13:05")},
+		{"list", "0 8,20 * * *", utc("2024-03-01 09:00"), utc("2024-03-01 20:00")},
+
+		// 2024-03-01 is a Friday
+		{"day of week", "0 9 * * 1", utc("2024-03-01 10:00"), utc("2024-03-04 09:00")},
+		{"7 is Sunday", "0 9 * * 7", utc("2024-03-01 10:00"), utc("2024-03-03 09:00")},
+		{"0 is Sunday", "0 9 * * 0", utc("2024-03-01 10:00"), utc("2024-03-03 09:00")},
+		{"weekly is Sunday", "@weekly", utc("2024-03-01 10:00"), utc("2024-03-03 00:00")},
+		{"weekdays", "0 9 * * 1-5", utc("2024-03-01 10:00"), utc("2024-03-04 09:00")},
+		{"weekend through 7", "0 9 * * 6-7", utc("2024-03-02 10:00"), utc("2024-03-03 09:00")},
+		{"day of month only", "0 0 15 * *", utc("2024-03-01 10:00"), utc("2024-03-15 00:00")},
+		{"day of month and week either matches", "0 0 15 * 1", utc("2024-03-01 10:00"), utc("2024-03-04 00:00")},
+		{"day of month and week, month first", "0 0 2 * 1", utc("2024-03-01 10:00"), utc("2024-03-02 00:00")},
+		{"day of month with any day of week", "0 0 15 * ?", utc("2024-03-01 10:00"), utc("2024-03-15 00:00")},
+		{"leap day", "0 0 29 2 *", utc("2024-03-01 00:00"), utc("2028-02-29 00:00")},
+		{"never matches", "0 0 30 2 *", utc("2024-03-01 00:00"), time.Time{}},
+
+		{"interval", "@every 90m", utc("2024-03-01 12:00"), utc("2024-03-01 13:30")},
+
+		// clocks in New York went from 02:00 to 03:00 on 2024-03-10
+		{"time in DST gap is skipped", "30 2 * * *", inNewYork("2024-03-10 00:00"), inNewYork("2024-03-11 02:30")},
+		{"hour after DST gap", "30 3 * * *", inNewYork("2024-03-10 00:00"), inNewYork("2024-03-10 03:30")},
+		{"every minute across DST gap", "* * * * *", inNewYork("2024-03-10 01:59"), inNewYork("2024-03-10 03:00")},
+		// and in Santiago from 00:00 to 01:00 on 2024-09-08
+		{"day starting in DST gap", "0 9 * * *", inSantiago("2024-09-07 10:00"), inSantiago("2024-09-08 09:00")},
+		{"stays in the location", "0 9 * * *", inNewYork("2024-03-09 10:00"), inNewYork("2024-03-10 09:00")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.spec, err)
+			}
+			got := s.Next(tt.from)
+			if !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, want %s", tt.from, got, tt.want)
+			}
+			if !got.IsZero() && got.Location() != tt.from.Location() {
+				t.Errorf("Next(%s) is in %s, want %s", tt.from, got.Location(), tt.from.Location())
+			}
+		})
+	}
+}
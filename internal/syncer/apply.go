@@ -0,0 +1,437 @@
+package syncer
+
+import (
+	"context"
+	"dbpiper/database/models"
+	"dbpiper/internal/airtable"
+	"dbpiper/internal/databases/pgx"
+	"dbpiper/types"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+)
+
+const (
+	// recordsPerFormula bounds how many record IDs go in one filterByFormula
+	// so the request URL stays short.
+	recordsPerFormula = 50
+	// keysPerQuery bounds how many rows are looked up by key at once.
+	keysPerQuery = 500
+)
+
+// Apply delivers change events that came from one side of a sync to the
+// other. Events only identify records; their current values are read from
+// the source side so late or repeated events still write the latest state.
+// Several events for one record are first folded into its net change.
+func (r *Runner) Apply(ctx context.Context, syncID, source string, events []types.ChangeEvent) error {
+	sync, err := r.DB.FindSyncByID(ctx, syncID)
+	if err != nil {
+		return err
+	}
+	if sync.Status == models.SyncPaused {
+		return ErrSyncPaused
+	}
+	if !acceptsChangesFrom(sync, source) {
+		log.Printf("sync %s: dropping %d %s events, direction is %s", sync.ID, len(events), source, sync.Direction)
+		return nil
+	}
+
+	j, err := r.prepare(ctx, sync)
+	if err != nil {
+		return err
+	}
+
+	byTable := make(map[string][]types.ChangeEvent)
+	for _, e := range coalesce(events) {
+		byTable[e.Table] = append(byTable[e.Table], e)
+	}
+
+	for _, t := range j.tables {
+		tableEvents := byTable[t.SourceTable]
+		if len(tableEvents) == 0 {
+			continue
+		}
+		switch source {
+		case models.QueueSourceDatabase:
+			err = r.applyPgChanges(ctx, j, t, tableEvents)
+		case models.QueueSourceAirtable:
+			err = r.applyAirtableChanges(ctx, j, t, tableEvents)
+		}
+		if err != nil {
+			return fmt.Errorf("table %s: %w", t.SourceTable, err)
+		}
+	}
+	return nil
+}
+
+func acceptsChangesFrom(sync *models.Sync, source string) bool {
+	switch source {
+	case models.QueueSourceDatabase:
+		return sync.Direction == models.PgToAirtable || sync.Direction == models.Bidirectional
+	case models.QueueSourceAirtable:
+		return sync.Direction == models.AirtableToPg || sync.Direction == models.Bidirectional
+	}
+	return false
+}
+
+// applyPgChanges re-reads the changed rows and writes them to Airtable.
+// Rows that no longer exist were deleted, whatever the event said. Rows
+// whose mapped values are what the last sync left are skipped: in a two
+// way sync those are mostly the echo of dbpiper's own writes.
+func (r *Runner) applyPgChanges(ctx context.Context, j *job, t types.TableConfig, events []types.ChangeEvent) error {
+	pt, err := r.pgTableFor(ctx, j, t)
+	if err != nil {
+		return err
+	}
+
+	var keys [][]string
+	seen := make(map[string]bool)
+	for _, e := range events {
+		if len(e.Key) != len(pt.key) {
+			continue
+		}
+		if k := encodeKey(e.Key); !seen[k] {
+			seen[k] = true
+			keys = append(keys, e.Key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	rows, err := r.readPgRows(ctx, j, t, pt, keys)
+	if err != nil {
+		return err
+	}
+	links, err := r.linksByKey(ctx, j, t, slices.Collect(maps.Keys(rows)))
+	if err != nil {
+		return err
+	}
+	var changed []*pgSnapshot
+	var missing []string
+	for k := range seen {
+		row, ok := rows[k]
+		switch {
+		case !ok:
+			missing = append(missing, k)
+		case links[k].PgHash == fingerprint(row.fields, t):
+		default:
+			changed = append(changed, row)
+		}
+	}
+
+	var toPg []types.Record
+	if j.sync.Direction == models.Bidirectional {
+		if changed, toPg, err = r.checkAirtableSide(ctx, j, t, pt, changed, links); err != nil {
+			return err
+		}
+	}
+	toAirtable := make([]airtableRow, len(changed))
+	for i, row := range changed {
+		toAirtable[i] = airtableRow{key: row.key, fields: row.fields}
+	}
+	if _, _, _, err := r.pushToAirtable(ctx, j, t, toAirtable); err != nil {
+		return err
+	}
+	if _, _, err := r.pushToPg(ctx, j, t, toPg); err != nil {
+		return err
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+	gone, err := r.DB.GetRecordLinksByKeys(ctx, j.sync.ID.String(), t.SourceTable, missing)
+	if err != nil {
+		return err
+	}
+	return r.tombstone(ctx, j, t, live(gone), models.Pgx)
+}
+
+// checkAirtableSide is the two way half of applyPgChanges. It reads the
+// records linked to the changed rows and settles those that changed in
+// Airtable as well by the sync's conflict policy, as reconcile does. It
+// returns the rows still to be written to Airtable and the records that
+// won over theirs. Rows of a record with a conflict already held are not
+// written; the held conflict is brought up to date instead.
+func (r *Runner) checkAirtableSide(ctx context.Context, j *job, t types.TableConfig, pt *pgTable, rows []*pgSnapshot, links map[string]models.RecordLink) ([]*pgSnapshot, []types.Record, error) {
+	var recordIDs []string
+	for _, row := range rows {
+		if l, ok := links[row.key]; ok {
+			recordIDs = append(recordIDs, l.RecordID)
+		}
+	}
+	if len(recordIDs) == 0 {
+		return rows, nil, nil
+	}
+	held, err := r.heldConflicts(ctx, j, t)
+	if err != nil {
+		return nil, nil, err
+	}
+	records, err := r.readAirtableRecords(ctx, j, t, pt, recordIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	byID := make(map[string]types.Record, len(records))
+	for _, rec := range records {
+		byID[rec.ID] = rec
+	}
+
+	toAirtable := rows[:0]
+	var toPg []types.Record
+	for _, row := range rows {
+		l, linked := links[row.key]
+		rec, inAirtable := byID[l.RecordID]
+		switch {
+		case linked && held[l.RecordID]:
+			if inAirtable {
+				if err := r.holdConflict(ctx, j, t, l, row, rec); err != nil {
+					return nil, nil, err
+				}
+			}
+			continue
+		case !inAirtable || fingerprint(rec.Fields, t) == l.AirtableHash:
+			toAirtable = append(toAirtable, row)
+			continue
+		}
+
+		switch decideConflict(j.sync.ConflictPolicy, t, row, rec) {
+		case models.Pgx:
+			toAirtable = append(toAirtable, row)
+		case models.Airtable:
+			toPg = append(toPg, rec)
+		default:
+			if err := r.holdConflict(ctx, j, t, l, row, rec); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return toAirtable, toPg, nil
+}
+
+// linksByKey returns the live links of keys by primary key.
+func (r *Runner) linksByKey(ctx context.Context, j *job, t types.TableConfig, keys []string) (map[string]models.RecordLink, error) {
+	byKey := make(map[string]models.RecordLink, len(keys))
+	for chunk := range slices.Chunk(keys, keysPerQuery) {
+		links, err := r.DB.GetRecordLinksByKeys(ctx, j.sync.ID.String(), t.SourceTable, chunk)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range live(links) {
+			byKey[l.PrimaryKey] = l
+		}
+	}
+	return byKey, nil
+}
+
+// heldConflicts returns the record IDs of t with a conflict pending review.
+func (r *Runner) heldConflicts(ctx context.Context, j *job, t types.TableConfig) (map[string]bool, error) {
+	conflicts, err := r.DB.GetSyncConflicts(ctx, j.sync.ID.String(), models.ConflictPending)
+	if err != nil {
+		return nil, err
+	}
+	held := make(map[string]bool, len(conflicts))
+	for _, c := range conflicts {
+		if c.SourceTable == t.SourceTable {
+			held[c.RecordID] = true
+		}
+	}
+	return held, nil
+}
+
+// readPgRows loads the rows with the given keys. Keys with no row are left
+// out of the result.
+func (r *Runner) readPgRows(ctx context.Context, j *job, t types.TableConfig, pt *pgTable, keys [][]string) (map[string]*pgSnapshot, error) {
+	columns := pt.readColumns(t)
+	snapshots := make(map[string]*pgSnapshot, len(keys))
+	for chunk := range slices.Chunk(keys, keysPerQuery) {
+		args := make([]any, 0, len(chunk)*len(pt.key))
+		for _, key := range chunk {
+			for _, v := range key {
+				args = append(args, v)
+			}
+		}
+		rows, err := j.pg.Query(ctx, pgx.SelectByKeysQuery(pt.name, columns, pt.key, len(chunk)), args...)
+		if err != nil {
+			return nil, err
+		}
+		found, err := pt.scanSnapshots(rows, t, columns)
+		if err != nil {
+			return nil, err
+		}
+		for k, row := range found {
+			snapshots[k] = row
+		}
+	}
+	return snapshots, nil
+}
+
+// applyAirtableChanges re-reads the changed records and writes them to
+// Postgres. Records that no longer exist were deleted. Like rows, records
+// that are as the last sync left them are skipped.
+func (r *Runner) applyAirtableChanges(ctx context.Context, j *job, t types.TableConfig, events []types.ChangeEvent) error {
+	pt, err := r.pgTableFor(ctx, j, t)
+	if err != nil {
+		return err
+	}
+
+	var recordIDs []string
+	for _, e := range events {
+		if e.RecordID == "" || slices.Contains(recordIDs, e.RecordID) {
+			continue
+		}
+		recordIDs = append(recordIDs, e.RecordID)
+	}
+	if len(recordIDs) == 0 {
+		return nil
+	}
+
+	records, err := r.readAirtableRecords(ctx, j, t, pt, recordIDs)
+	if err != nil {
+		return err
+	}
+	missing := slices.DeleteFunc(recordIDs, func(id string) bool {
+		return slices.ContainsFunc(records, func(rec types.Record) bool { return rec.ID == id })
+	})
+
+	found := make([]string, len(records))
+	for i, rec := range records {
+		found[i] = rec.ID
+	}
+	links, err := r.linksByRecordID(ctx, j, t, found)
+	if err != nil {
+		return err
+	}
+	changed := slices.DeleteFunc(records, func(rec types.Record) bool {
+		return links[rec.ID].AirtableHash == fingerprint(rec.Fields, t)
+	})
+
+	var toAirtable []airtableRow
+	if j.sync.Direction == models.Bidirectional {
+		if changed, toAirtable, err = r.checkPgSide(ctx, j, t, pt, changed, links); err != nil {
+			return err
+		}
+	}
+	if _, _, err := r.pushToPg(ctx, j, t, changed); err != nil {
+		return err
+	}
+	if _, _, _, err := r.pushToAirtable(ctx, j, t, toAirtable); err != nil {
+		return err
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+	gone, err := r.DB.GetRecordLinksByRecordIDs(ctx, j.sync.ID.String(), t.SourceTable, missing)
+	if err != nil {
+		return err
+	}
+	return r.tombstone(ctx, j, t, live(gone), models.Airtable)
+}
+
+// checkPgSide is the two way half of applyAirtableChanges and the mirror of
+// checkAirtableSide: it settles records whose linked row changed as well,
+// and returns the records still to be written to Postgres and the rows that
+// won over theirs.
+func (r *Runner) checkPgSide(ctx context.Context, j *job, t types.TableConfig, pt *pgTable, records []types.Record, links map[string]models.RecordLink) ([]types.Record, []airtableRow, error) {
+	var keys [][]string
+	for _, rec := range records {
+		l, ok := links[rec.ID]
+		if !ok {
+			continue
+		}
+		key, err := decodeKey(l.PrimaryKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return records, nil, nil
+	}
+	held, err := r.heldConflicts(ctx, j, t)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows, err := r.readPgRows(ctx, j, t, pt, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	toPg := records[:0]
+	var toAirtable []airtableRow
+	for _, rec := range records {
+		l, linked := links[rec.ID]
+		row, inPg := rows[l.PrimaryKey]
+		switch {
+		case linked && held[rec.ID]:
+			if inPg {
+				if err := r.holdConflict(ctx, j, t, l, row, rec); err != nil {
+					return nil, nil, err
+				}
+			}
+			continue
+		case !inPg || fingerprint(row.fields, t) == l.PgHash:
+			toPg = append(toPg, rec)
+			continue
+		}
+
+		switch decideConflict(j.sync.ConflictPolicy, t, row, rec) {
+		case models.Airtable:
+			toPg = append(toPg, rec)
+		case models.Pgx:
+			toAirtable = append(toAirtable, airtableRow{key: row.key, fields: row.fields})
+		default:
+			if err := r.holdConflict(ctx, j, t, l, row, rec); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return toPg, toAirtable, nil
+}
+
+// linksByRecordID returns the live links of recordIDs by record ID.
+func (r *Runner) linksByRecordID(ctx context.Context, j *job, t types.TableConfig, recordIDs []string) (map[string]models.RecordLink, error) {
+	byID := make(map[string]models.RecordLink, len(recordIDs))
+	for chunk := range slices.Chunk(recordIDs, keysPerQuery) {
+		links, err := r.DB.GetRecordLinksByRecordIDs(ctx, j.sync.ID.String(), t.SourceTable, chunk)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range live(links) {
+			byID[l.RecordID] = l
+		}
+	}
+	return byID, nil
+}
+
+// live leaves out links that are already tombstoned.
+func live(links []models.RecordLink) []models.RecordLink {
+	return slices.DeleteFunc(links, func(l models.RecordLink) bool { return l.TombstonedAt != nil })
+}
+
+// readAirtableRecords fetches the given records. Records that no longer
+// exist are left out of the result.
+func (r *Runner) readAirtableRecords(ctx context.Context, j *job, t types.TableConfig, pt *pgTable, recordIDs []string) ([]types.Record, error) {
+	var records []types.Record
+	for chunk := range slices.Chunk(recordIDs, recordsPerFormula) {
+		conditions := make([]string, len(chunk))
+		for i, id := range chunk {
+			conditions[i] = fmt.Sprintf("RECORD_ID()='%s'", strings.ReplaceAll(id, "'", `\'`))
+		}
+
+		params := types.ListRecordsParams{
+			PageSize:              airtable.MaxPageSize,
+			Fields:                pt.fieldIDs,
+			FilterByFormula:       "OR(" + strings.Join(conditions, ",") + ")",
+			ReturnFieldsByFieldID: true,
+		}
+		page, err := j.airtable.ListRecords(ctx, airtableTableOf(j.sync, t), params)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, page.Records...)
+	}
+	return records, nil
+}
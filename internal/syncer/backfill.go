@@ -0,0 +1,270 @@
+package syncer
+
+import (
+	"context"
+	"dbpiper/database/models"
+	"dbpiper/internal/airtable"
+	"dbpiper/internal/databases/pgx"
+	"dbpiper/types"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"time"
+)
+
+const (
+	// backfillChunkSize is how many Postgres rows one keyset query reads.
+	backfillChunkSize = 1000
+	// incrementalSweepInterval is how often a table read by cursor column
+	// is checked for deleted rows, which means reading every linked key.
+	incrementalSweepInterval = 24 * time.Hour
+)
+
+// ValidateCursorColumn checks that a table is only read by cursor column
+// where Postgres is the one source.
+func ValidateCursorColumn(direction models.SyncDirection, t types.TableConfig) error {
+	if t.CursorColumn != "" && direction != models.PgToAirtable {
+		return fmt.Errorf("table %s: cursor_column needs a %s sync", t.SourceTable, models.PgToAirtable)
+	}
+	return nil
+}
+
+// progressFor returns the backfill progress of a table, creating an empty
+// one the first time the table is seen.
+func (j *job) progressFor(table string) *models.BackfillProgress {
+	if p, ok := j.progress[table]; ok {
+		return p
+	}
+	p := &models.BackfillProgress{SyncID: j.sync.ID, SourceTable: table}
+	j.progress[table] = p
+	return p
+}
+
+// backfillPgToAirtable copies every row of a Postgres table into Airtable in
+// primary key order. The table is read in chunks of backfillChunkSize rows
+// by keyset, so no query stays open for the whole table. The last key
+// written is checkpointed after each batch: a failed or interrupted
+// backfill resumes right after it, and rows already linked to a record
+// update it instead of creating a duplicate. Once the pass is done, linked
+// rows that are gone are handled as deletes. A table with a cursor column
+// is read whole once; later passes only read rows changed since the mark
+// taken when the previous pass started, and look for gone rows at most
+// once every incrementalSweepInterval.
+func (r *Runner) backfillPgToAirtable(ctx context.Context, j *job, t types.TableConfig) error {
+	progress := j.progressFor(t.SourceTable)
+	if progress.Completed {
+		return nil
+	}
+
+	key, err := pgx.PrimaryKey(ctx, j.pg, t.SourceTable)
+	if err != nil {
+		return err
+	}
+
+	// the mark is taken before anything is read, and kept by a pass that
+	// resumes, so rows changed while the pass runs are read by the next one
+	if t.CursorColumn != "" && progress.NextHighWater == "" {
+		mark, err := pgx.CursorMark(ctx, j.pg, t.CursorColumn)
+		if err != nil {
+			return err
+		}
+		progress.NextHighWater = mark
+		if err := r.DB.SaveBackfillProgress(ctx, progress); err != nil {
+			return err
+		}
+	}
+	incremental := t.CursorColumn != "" && progress.HighWater != ""
+
+	var cursor []string
+	if len(progress.Cursor) > 0 {
+		if err := json.Unmarshal(progress.Cursor, &cursor); err != nil {
+			return fmt.Errorf("invalid backfill cursor: %w", err)
+		}
+		log.Printf("sync %s: resuming backfill of %s after key %v", j.sync.ID, t.SourceTable, cursor)
+	}
+
+	columns := slices.Sorted(maps.Keys(t.Fields))
+	batch := make([]airtableRow, 0, airtable.MaxRecordsPerRequest)
+	lastKey := make([]string, len(key))
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		_, created, updated, err := r.pushToAirtable(ctx, j, t, batch)
+		if err != nil {
+			return err
+		}
+		progress.RowsWritten += int64(created + updated)
+		progress.Cursor, _ = json.Marshal(lastKey)
+		batch = batch[:0]
+		return r.DB.SaveBackfillProgress(ctx, progress)
+	}
+
+	for {
+		args := make([]any, len(cursor))
+		for i, v := range cursor {
+			args[i] = v
+		}
+		var where string
+		if incremental {
+			where = pgx.ChangedSince(t.CursorColumn, len(args)+1)
+			args = append(args, progress.HighWater)
+		}
+		query := pgx.KeysetQueryWhere(t.SourceTable, columns, key, len(cursor) > 0, where) + fmt.Sprintf(" LIMIT %d", backfillChunkSize)
+		rows, err := j.pg.Query(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+
+		n := 0
+		for rows.Next() {
+			values, err := rows.Values()
+			if err != nil {
+				rows.Close()
+				return err
+			}
+
+			for i := range key {
+				lastKey[i], _ = values[i].(string)
+			}
+			fields := make(map[string]any, len(columns))
+			for i, column := range columns {
+				fields[t.Fields[column]] = airtableValue(values[len(key)+i])
+			}
+
+			batch = append(batch, airtableRow{key: encodeKey(lastKey), fields: fields})
+			progress.RowsRead++
+			j.statsFor(t.SourceTable).RowsRead++
+			n++
+
+			if len(batch) == airtable.MaxRecordsPerRequest {
+				if err := flush(); err != nil {
+					rows.Close()
+					return err
+				}
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+		if n < backfillChunkSize {
+			break
+		}
+		cursor = slices.Clone(lastKey)
+	}
+
+	progress.Completed = true
+	if t.CursorColumn != "" {
+		progress.HighWater, progress.NextHighWater = progress.NextHighWater, ""
+	}
+	if err := r.DB.SaveBackfillProgress(ctx, progress); err != nil {
+		return err
+	}
+	if incremental {
+		log.Printf("sync %s: copied %d changed rows from %s", j.sync.ID, progress.RowsWritten, t.SourceTable)
+	} else {
+		log.Printf("sync %s: backfilled %d rows from %s", j.sync.ID, progress.RowsWritten, t.SourceTable)
+	}
+
+	if incremental && progress.SweptAt != nil && time.Since(*progress.SweptAt) < incrementalSweepInterval {
+		return nil
+	}
+	if err := r.sweepPgDeletes(ctx, j, t); err != nil {
+		return err
+	}
+	now := time.Now()
+	progress.SweptAt = &now
+	return r.DB.SaveBackfillProgress(ctx, progress)
+}
+
+// backfillAirtableToPg pages through every record of an Airtable table and
+// writes it to Postgres, updating the rows records are already linked to.
+// The offset of the next page is checkpointed after each page. Airtable
+// offsets expire, so a backfill resumed too late starts the table over;
+// records written before are linked and only updated again. A pass that
+// listed the whole table in one go also finds the records deleted since.
+func (r *Runner) backfillAirtableToPg(ctx context.Context, j *job, t types.TableConfig) error {
+	progress := j.progressFor(t.SourceTable)
+	if progress.Completed {
+		return nil
+	}
+
+	pt, err := r.pgTableFor(ctx, j, t)
+	if err != nil {
+		return err
+	}
+
+	params := types.ListRecordsParams{
+		PageSize:              airtable.MaxPageSize,
+		Fields:                pt.fieldIDs,
+		ReturnFieldsByFieldID: true,
+	}
+	if len(progress.Cursor) > 0 {
+		if err := json.Unmarshal(progress.Cursor, &params.Offset); err != nil {
+			return fmt.Errorf("invalid backfill cursor: %w", err)
+		}
+	}
+	if params.Offset != "" {
+		log.Printf("sync %s: resuming backfill of %s at offset %s", j.sync.ID, t.SourceTable, params.Offset)
+	}
+
+	// records missing from a pass that saw the whole table were deleted
+	var seen map[string]bool
+	if params.Offset == "" {
+		seen = make(map[string]bool)
+	}
+
+	for {
+		page, err := j.airtable.ListRecords(ctx, t.SourceTable, params)
+		if params.Offset != "" && airtable.IsOffsetExpired(err) {
+			log.Printf("sync %s: backfill offset of %s expired, starting the table over", j.sync.ID, t.SourceTable)
+			params.Offset = ""
+			progress.RowsRead, progress.RowsWritten = 0, 0
+			seen = make(map[string]bool)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		created, updated, err := r.pushToPg(ctx, j, t, page.Records)
+		if err != nil {
+			return err
+		}
+		if seen != nil {
+			for _, rec := range page.Records {
+				seen[rec.ID] = true
+			}
+		}
+
+		progress.RowsRead += int64(len(page.Records))
+		progress.RowsWritten += int64(created + updated)
+		progress.Cursor, _ = json.Marshal(page.Offset)
+		j.statsFor(t.SourceTable).RowsRead += int64(len(page.Records))
+		if err := r.DB.SaveBackfillProgress(ctx, progress); err != nil {
+			return err
+		}
+
+		if page.Offset == "" {
+			break
+		}
+		params.Offset = page.Offset
+	}
+
+	progress.Completed = true
+	if err := r.DB.SaveBackfillProgress(ctx, progress); err != nil {
+		return err
+	}
+	log.Printf("sync %s: backfilled %d records from %s", j.sync.ID, progress.RowsWritten, t.SourceTable)
+	if seen == nil {
+		return nil
+	}
+	return r.sweepAirtableDeletes(ctx, j, t, seen)
+}
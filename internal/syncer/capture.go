@@ -0,0 +1,105 @@
+package syncer
+
+import (
+	"context"
+	"dbpiper/database/models"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const captureRefreshInterval = 30 * time.Second
+
+// captureFunc consumes the Postgres changes of one sync until ctx is
+// cancelled or it fails.
+type captureFunc func(ctx context.Context, syncID string) error
+
+// Capture keeps one change consumer running per active sync that captures
+// Postgres changes. A consumer that fails is started again on the next
+// refresh and carries on from what was last delivered. Consumers claim
+// their source exclusively, so several dbpiper instances can run Capture
+// side by side.
+type Capture struct {
+	Runner *Runner
+
+	mu        sync.Mutex
+	consumers map[string]context.CancelFunc
+}
+
+func NewCapture(runner *Runner) *Capture {
+	return &Capture{
+		Runner:    runner,
+		consumers: make(map[string]context.CancelFunc),
+	}
+}
+
+// Run manages the consumers until ctx is cancelled.
+func (c *Capture) Run(ctx context.Context) {
+	ticker := time.NewTicker(captureRefreshInterval)
+	defer ticker.Stop()
+	for {
+		if err := c.refresh(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("capture: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Capture) refresh(ctx context.Context) error {
+	syncs, err := c.Runner.DB.GetCaptureSyncs(ctx, []models.SyncStatus{models.SyncActive})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	active := make(map[string]bool, len(syncs))
+	for _, s := range syncs {
+		id := s.ID.String()
+		fn, err := c.Runner.captureFunc(s.CaptureMode)
+		if err != nil {
+			log.Printf("capture: sync %s: %v", id, err)
+			continue
+		}
+		active[id] = true
+		if _, ok := c.consumers[id]; ok {
+			continue
+		}
+
+		consumerCtx, cancel := context.WithCancel(ctx)
+		c.consumers[id] = cancel
+		go func() {
+			err := fn(consumerCtx, id)
+			if err != nil && consumerCtx.Err() == nil {
+				log.Printf("capture: sync %s: %v", id, err)
+			}
+			c.mu.Lock()
+			delete(c.consumers, id)
+			c.mu.Unlock()
+			cancel()
+		}()
+	}
+
+	for id, cancel := range c.consumers {
+		if !active[id] {
+			cancel()
+		}
+	}
+	return nil
+}
+
+func (r *Runner) captureFunc(mode models.CaptureMode) (captureFunc, error) {
+	switch mode {
+	case models.CaptureReplication:
+		return r.streamChanges, nil
+	case models.CaptureTrigger:
+		return r.drainChangelog, nil
+	}
+	return nil, fmt.Errorf("unknown capture mode %q", mode)
+}
@@ -0,0 +1,133 @@
+package syncer
+
+import (
+	"context"
+	"dbpiper/database/models"
+	"dbpiper/internal/databases/pgx"
+	"dbpiper/types"
+	"errors"
+	"fmt"
+	"time"
+
+	pgxv5 "github.com/jackc/pgx/v5"
+)
+
+const (
+	// changelogPollInterval is the safety poll for notifications that were
+	// lost; the triggers wake the reader as soon as a change commits
+	changelogPollInterval = 30 * time.Second
+	changelogBatchSize    = 500
+)
+
+// changelogOf names the changelog objects a sync owns.
+func changelogOf(sync *models.Sync) pgx.Changelog {
+	return pgx.NewChangelog(ReplicationSlot(sync))
+}
+
+// installChangelog sets up trigger capture on every mapped table.
+func (r *Runner) installChangelog(ctx context.Context, j *job) error {
+	if j.sync.Direction == models.AirtableToPg {
+		return errors.New("trigger capture needs Postgres as the source")
+	}
+	keys := make(map[string][]pgx.KeyColumn, len(j.tables))
+	for _, t := range j.tables {
+		pt, err := r.pgTableFor(ctx, j, t)
+		if err != nil {
+			return err
+		}
+		keys[pt.name] = pt.key
+	}
+	return pgx.InstallChangelog(ctx, j.pg, changelogOf(j.sync), keys)
+}
+
+// drainChangelog delivers the changelog of a sync in order. Entries are
+// claimed in a transaction, applied, and deleted when the transaction
+// commits, so a failed delivery leaves them in place for the next attempt.
+// That transaction stays open while the entries are applied, so it runs on
+// a connection of its own: on the sync's pool it would hold one of the few
+// connections Apply needs to read the changed rows.
+// It sleeps until the triggers notify it, or the safety poll comes round.
+func (r *Runner) drainChangelog(ctx context.Context, syncID string) error {
+	sync, err := r.DB.FindSyncByID(ctx, syncID)
+	if err != nil {
+		return err
+	}
+	tables, err := DecodeTables(sync.Tables)
+	if err != nil {
+		return err
+	}
+	connID, dsn, err := r.pgDSN(ctx, sync)
+	if err != nil {
+		return err
+	}
+	conn, err := pgxv5.Connect(ctx, dsn)
+	if err != nil {
+		return fmt.Errorf("database connection %s: %w", connID, err)
+	}
+	defer conn.Close(context.Background())
+
+	byPgTable := make(map[string]string, len(tables))
+	for _, t := range tables {
+		byPgTable[pgTableOf(sync, t)] = t.SourceTable
+	}
+	cl := changelogOf(sync)
+	wake := r.Listener.Subscribe(connID, dsn, cl.Table)
+	defer wake.Close()
+
+	for {
+		var n int
+		err := pgxv5.BeginFunc(ctx, conn, func(tx pgxv5.Tx) error {
+			entries, err := pgx.ClaimChangelog(ctx, tx, cl, changelogBatchSize)
+			if err != nil {
+				return err
+			}
+			n = len(entries)
+			if n == 0 {
+				return nil
+			}
+
+			events := make([]types.ChangeEvent, 0, n)
+			ids := make([]int64, n)
+			for i, e := range entries {
+				ids[i] = e.ID
+				table, ok := byPgTable[e.Table]
+				if !ok {
+					continue
+				}
+				op := types.ChangeUpdate
+				switch e.Op {
+				case "I":
+					op = types.ChangeCreate
+				case "D":
+					op = types.ChangeDelete
+				}
+				events = append(events, types.ChangeEvent{Table: table, Op: op, Key: e.Key})
+			}
+
+			if err := r.Apply(ctx, syncID, models.QueueSourceDatabase, events); err != nil {
+				return err
+			}
+			return pgx.PruneChangelog(ctx, tx, cl, ids)
+		})
+		if err != nil {
+			return err
+		}
+
+		// keep going while there is a backlog
+		if n == changelogBatchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-wake.C:
+			// let the rest of a burst reach the changelog first
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(r.CoalesceWindow):
+			}
+		case <-time.After(changelogPollInterval):
+		}
+	}
+}
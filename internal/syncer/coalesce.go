@@ -0,0 +1,138 @@
+package syncer
+
+import (
+	"context"
+	"dbpiper/database"
+	"dbpiper/database/models"
+	"dbpiper/types"
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	// defaultCoalesceWindow is how long changes are held back so a burst of
+	// them for the same records is delivered as one net change.
+	defaultCoalesceWindow = time.Second
+	// maxCoalescedItems bounds how many queue rows are folded into one.
+	maxCoalescedItems = 100
+)
+
+// coalesceWindow reads SYNC_COALESCE_WINDOW, a duration such as "2s". Zero
+// delivers changes as soon as they arrive.
+func coalesceWindow() time.Duration {
+	v := os.Getenv("SYNC_COALESCE_WINDOW")
+	if v == "" {
+		return defaultCoalesceWindow
+	}
+	window, err := time.ParseDuration(v)
+	if err != nil || window < 0 {
+		log.Printf("invalid SYNC_COALESCE_WINDOW %q, using %s", v, defaultCoalesceWindow)
+		return defaultCoalesceWindow
+	}
+	return window
+}
+
+// coalesce folds the events for the same record into its net change, in the
+// order each record first changed. A record created and deleted again in the
+// same burst drops out entirely.
+func coalesce(events []types.ChangeEvent) []types.ChangeEvent {
+	index := make(map[string]int, len(events))
+	net := make([]types.ChangeEvent, 0, len(events))
+	for _, e := range events {
+		id := recordOf(e)
+		i, ok := index[id]
+		if !ok {
+			index[id] = len(net)
+			net = append(net, e)
+			continue
+		}
+		net[i].Op = netOp(net[i].Op, e.Op)
+	}
+
+	out := net[:0]
+	for _, e := range net {
+		if e.Op != "" {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// recordOf identifies the record an event is about.
+func recordOf(e types.ChangeEvent) string {
+	if e.RecordID != "" {
+		return e.Table + "\x00" + e.RecordID
+	}
+	return e.Table + "\x00" + encodeKey(e.Key)
+}
+
+// netOp is what a change followed by another amounts to. An empty op means
+// nothing happened.
+func netOp(prev, next types.ChangeOp) types.ChangeOp {
+	switch {
+	case prev == "":
+		return next
+	case prev == types.ChangeCreate && next == types.ChangeDelete:
+		return ""
+	case prev == types.ChangeCreate:
+		return types.ChangeCreate
+	case prev == types.ChangeDelete && next != types.ChangeDelete:
+		// the key was taken again; whatever is there now replaces the old
+		return types.ChangeUpdate
+	}
+	return next
+}
+
+// coalescesSource reports whether queue rows from source may be folded
+// together: change rows merge their events, and one read of an Airtable
+// webhook's payloads covers every ping that came before it.
+func coalescesSource(source string) bool {
+	switch source {
+	case models.QueueSourceDatabase, models.QueueSourceAirtable, models.QueueSourceAirtableWebhook:
+		return true
+	}
+	return false
+}
+
+// claimBurst claims the other pending rows of item's sync and source and
+// folds them into item. It returns the IDs of the rows item now stands
+// for, item's own included. Rows that cannot be folded are left alone.
+func claimBurst(ctx context.Context, tx database.DB, item *models.WebhookQueue) ([]int, error) {
+	ids := []int{item.ID}
+	if !coalescesSource(item.Source) {
+		return ids, nil
+	}
+	burst, err := tx.ClaimPendingQueueItems(ctx, item.SyncID, item.Source, item.ID, maxCoalescedItems)
+	if err != nil || len(burst) == 0 {
+		return ids, err
+	}
+
+	if item.Source == models.QueueSourceAirtableWebhook {
+		for _, b := range burst {
+			ids = append(ids, b.ID)
+		}
+		return ids, nil
+	}
+
+	var payload types.ChangePayload
+	if err := json.Unmarshal(item.Payload, &payload); err != nil {
+		// item fails on its own; the rest get their turn
+		return ids, nil
+	}
+	for _, b := range burst {
+		var more types.ChangePayload
+		if err := json.Unmarshal(b.Payload, &more); err != nil {
+			continue
+		}
+		payload.Events = append(payload.Events, more.Events...)
+		ids = append(ids, b.ID)
+	}
+	merged, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	item.Payload = merged
+	return ids, nil
+}
@@ -0,0 +1,109 @@
+package syncer
+
+import (
+	"dbpiper/types"
+	"slices"
+	"testing"
+)
+
+func TestNetOp(t *testing.T) {
+	const (
+		none   = types.ChangeOp("")
+		create = types.ChangeCreate
+		update = types.ChangeUpdate
+		del    = types.ChangeDelete
+	)
+	tests := []struct {
+		prev, next, want types.ChangeOp
+	}{
+		{none, create, create},
+		{none, update, update},
+		{none, del, del},
+		{create, update, create},
+		{create, create, create},
+		{create, del, none},
+		{update, update, update},
+		{update, del, del},
+		{update, create, create},
+		{del, create, update},
+		{del, update, update},
+		{del, del, del},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.prev)+"_"+string(tt.next), func(t *testing.T) {
+			if got := netOp(tt.prev, tt.next); got != tt.want {
+				t.Errorf("netOp(%q, %q) = %q, want %q", tt.prev, tt.next, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	pg := func(op types.ChangeOp, key ...string) types.ChangeEvent {
+		return types.ChangeEvent{Table: "people", Op: op, Key: key}
+	}
+	at := func(op types.ChangeOp, id string) types.ChangeEvent {
+		return types.ChangeEvent{Table: "people", Op: op, RecordID: id}
+	}
+
+	tests := []struct {
+		name   string
+		events []types.ChangeEvent
+		want   []types.ChangeEvent
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:   "updates fold into one",
+			events: []types.ChangeEvent{pg(types.ChangeUpdate, "1"), pg(types.ChangeUpdate, "1")},
+			want:   []types.ChangeEvent{pg(types.ChangeUpdate, "1")},
+		},
+		{
+			name:   "created then deleted drops out",
+			events: []types.ChangeEvent{pg(types.ChangeCreate, "1"), pg(types.ChangeUpdate, "1"), pg(types.ChangeDelete, "1")},
+		},
+		{
+			name:   "deleted then created again is an update",
+			events: []types.ChangeEvent{pg(types.ChangeDelete, "1"), pg(types.ChangeCreate, "1")},
+			want:   []types.ChangeEvent{pg(types.ChangeUpdate, "1")},
+		},
+		{
+			name:   "order of first change is kept",
+			events: []types.ChangeEvent{pg(types.ChangeUpdate, "2"), pg(types.ChangeCreate, "1"), pg(types.ChangeDelete, "2")},
+			want:   []types.ChangeEvent{pg(types.ChangeDelete, "2"), pg(types.ChangeCreate, "1")},
+		},
+		{
+			name:   "composite keys are told apart",
+			events: []types.ChangeEvent{pg(types.ChangeUpdate, "1", "2"), pg(types.ChangeUpdate, "12")},
+			want:   []types.ChangeEvent{pg(types.ChangeUpdate, "1", "2"), pg(types.ChangeUpdate, "12")},
+		},
+		{
+			name:   "records by ID",
+			events: []types.ChangeEvent{at(types.ChangeCreate, "recA"), at(types.ChangeUpdate, "recB"), at(types.ChangeUpdate, "recA")},
+			want:   []types.ChangeEvent{at(types.ChangeCreate, "recA"), at(types.ChangeUpdate, "recB")},
+		},
+		{
+			name: "tables are told apart",
+			events: []types.ChangeEvent{
+				pg(types.ChangeCreate, "1"),
+				{Table: "orders", Op: types.ChangeDelete, Key: []string{"1"}},
+			},
+			want: []types.ChangeEvent{
+				pg(types.ChangeCreate, "1"),
+				{Table: "orders", Op: types.ChangeDelete, Key: []string{"1"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := coalesce(tt.events)
+			equal := slices.EqualFunc(got, tt.want, func(a, b types.ChangeEvent) bool {
+				return a.Table == b.Table && a.Op == b.Op && a.RecordID == b.RecordID && slices.Equal(a.Key, b.Key)
+			})
+			if !equal {
+				t.Errorf("coalesce() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package syncer
+
+import (
+	"context"
+	"dbpiper/database/models"
+	"dbpiper/internal/airtable"
+	"dbpiper/internal/databases/pgx"
+	"dbpiper/types"
+	"fmt"
+	"log"
+	"slices"
+
+	pgxv5 "github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ValidateDeleteMode checks the delete handling of a table mapping against
+// the sides a sync writes to.
+func ValidateDeleteMode(direction models.SyncDirection, t types.TableConfig) error {
+	switch t.OnDelete {
+	case "", types.DeleteIgnore, types.DeleteHard:
+		return nil
+	case types.DeleteSoft:
+	default:
+		return fmt.Errorf("table %s: invalid on_delete %q", t.SourceTable, t.OnDelete)
+	}
+
+	if writesAirtable(direction) && t.SoftDeleteField == "" {
+		return fmt.Errorf("table %s: soft deletes need soft_delete_field", t.SourceTable)
+	}
+	if writesPg(direction) && t.SoftDeleteColumn == "" {
+		return fmt.Errorf("table %s: soft deletes need soft_delete_column", t.SourceTable)
+	}
+	return nil
+}
+
+func writesAirtable(direction models.SyncDirection) bool {
+	return direction == models.PgToAirtable || direction == models.Bidirectional
+}
+
+func writesPg(direction models.SyncDirection) bool {
+	return direction == models.AirtableToPg || direction == models.Bidirectional
+}
+
+// tombstone records that the rows or records of links are gone from one
+// side, then applies the deletes to the other. The tombstones are stored
+// first, so a delete that fails or is interrupted is retried by the next
+// run.
+func (r *Runner) tombstone(ctx context.Context, j *job, t types.TableConfig, links []models.RecordLink, from models.RepoType) error {
+	if len(links) == 0 {
+		return nil
+	}
+	ids := make([]int, len(links))
+	for i, l := range links {
+		ids[i] = l.ID
+	}
+	if err := r.DB.TombstoneRecordLinks(ctx, ids, from); err != nil {
+		return err
+	}
+	log.Printf("sync %s: %d rows of %s deleted from %s", j.sync.ID, len(links), t.SourceTable, from)
+	return r.propagateDeletes(ctx, j, t)
+}
+
+// propagateDeletes applies the pending tombstones of a table mapping to the
+// side that still has the row or record, as configured by OnDelete. Deletes
+// towards a side the sync does not write to are only recorded.
+func (r *Runner) propagateDeletes(ctx context.Context, j *job, t types.TableConfig) error {
+	pending, err := r.DB.GetPendingTombstones(ctx, j.sync.ID.String(), t.SourceTable)
+	if err != nil || len(pending) == 0 {
+		return err
+	}
+
+	var fromPg, fromAirtable []models.RecordLink
+	for _, l := range pending {
+		if l.DeletedFrom == models.Pgx {
+			fromPg = append(fromPg, l)
+		} else {
+			fromAirtable = append(fromAirtable, l)
+		}
+	}
+
+	mode := t.OnDelete
+	if mode == "" {
+		mode = types.DeleteIgnore
+	}
+	if mode != types.DeleteIgnore && writesAirtable(j.sync.Direction) {
+		if err := r.deleteInAirtable(ctx, j, t, mode, fromPg); err != nil {
+			return err
+		}
+	}
+	if mode != types.DeleteIgnore && writesPg(j.sync.Direction) {
+		if err := r.deleteInPg(ctx, j, t, mode, fromAirtable); err != nil {
+			return err
+		}
+	}
+
+	ids := make([]int, len(pending))
+	for i, l := range pending {
+		ids[i] = l.ID
+	}
+	return r.DB.MarkTombstonesPropagated(ctx, ids)
+}
+
+// deleteInAirtable deletes or flags the records of rows deleted from
+// Postgres. Records that are already gone count as done.
+func (r *Runner) deleteInAirtable(ctx context.Context, j *job, t types.TableConfig, mode types.DeleteMode, links []models.RecordLink) error {
+	tableID := airtableTableOf(j.sync, t)
+	write := func(ids []string) (int, error) {
+		if mode == types.DeleteHard {
+			deleted, err := j.airtable.DeleteRecords(ctx, tableID, ids)
+			return len(deleted), err
+		}
+		records := make([]types.Record, len(ids))
+		for i, id := range ids {
+			records[i] = types.Record{ID: id, Fields: map[string]any{t.SoftDeleteField: true}}
+		}
+		updated, err := j.airtable.UpdateRecords(ctx, tableID, records, true)
+		return len(updated), err
+	}
+
+	stats := j.statsFor(t.SourceTable)
+	for chunk := range slices.Chunk(links, airtable.MaxRecordsPerRequest) {
+		ids := make([]string, len(chunk))
+		for i, l := range chunk {
+			ids[i] = l.RecordID
+		}
+
+		n, err := write(ids)
+		if airtable.IsNotFound(err) {
+			// one missing record fails the whole request; retry the others
+			n, err = 0, nil
+			for _, id := range ids {
+				m, oneErr := write([]string{id})
+				if oneErr != nil && !airtable.IsNotFound(oneErr) {
+					err = oneErr
+					break
+				}
+				n += m
+			}
+		}
+		if err != nil {
+			stats.RowsFailed += int64(len(chunk))
+			return err
+		}
+		stats.RowsDeleted += int64(n)
+	}
+	return nil
+}
+
+// deleteInPg deletes or stamps the rows of records deleted from Airtable.
+// Rows that are already gone are skipped.
+func (r *Runner) deleteInPg(ctx context.Context, j *job, t types.TableConfig, mode types.DeleteMode, links []models.RecordLink) error {
+	if len(links) == 0 {
+		return nil
+	}
+	pt, err := r.pgTableFor(ctx, j, t)
+	if err != nil {
+		return err
+	}
+
+	query := pgx.DeleteQuery(pt.name, pt.key)
+	if mode == types.DeleteSoft {
+		query = pgx.SoftDeleteQuery(pt.name, t.SoftDeleteColumn, pt.key)
+	}
+
+	stats := j.statsFor(t.SourceTable)
+	for chunk := range slices.Chunk(links, keysPerQuery) {
+		// the batch runs in one implicit transaction; its deletes only
+		// count once it went through as a whole
+		var deleted int64
+		batch := &pgxv5.Batch{}
+		for _, l := range chunk {
+			values, err := decodeKey(l.PrimaryKey)
+			if err != nil {
+				return err
+			}
+			args := make([]any, len(values))
+			for i, v := range values {
+				args[i] = v
+			}
+			batch.Queue(query, args...).Exec(func(tag pgconn.CommandTag) error {
+				deleted += tag.RowsAffected()
+				return nil
+			})
+		}
+		if err := j.pg.SendBatch(ctx, batch).Close(); err != nil {
+			stats.RowsFailed += int64(len(chunk))
+			return err
+		}
+		stats.RowsDeleted += deleted
+	}
+	return nil
+}
+
+// sweepPgDeletes tombstones the links of a table whose Postgres row no
+// longer exists. It runs after a full pass of a one way backfill, which only
+// sees the rows that are there.
+func (r *Runner) sweepPgDeletes(ctx context.Context, j *job, t types.TableConfig) error {
+	pt, err := r.pgTableFor(ctx, j, t)
+	if err != nil {
+		return err
+	}
+	links, err := r.DB.GetRecordLinks(ctx, j.sync.ID.String(), t.SourceTable)
+	if err != nil {
+		return err
+	}
+	links = live(links)
+
+	var gone []models.RecordLink
+	for chunk := range slices.Chunk(links, keysPerQuery) {
+		keys := make([][]string, 0, len(chunk))
+		for _, l := range chunk {
+			values, err := decodeKey(l.PrimaryKey)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, values)
+		}
+		found, err := r.readPgRows(ctx, j, t, pt, keys)
+		if err != nil {
+			return err
+		}
+		for _, l := range chunk {
+			if _, ok := found[l.PrimaryKey]; !ok {
+				gone = append(gone, l)
+			}
+		}
+	}
+	return r.tombstone(ctx, j, t, gone, models.Pgx)
+}
+
+// sweepAirtableDeletes tombstones the links of a table whose record was not
+// among seen, the records listed by a full pass of a one way backfill.
+func (r *Runner) sweepAirtableDeletes(ctx context.Context, j *job, t types.TableConfig, seen map[string]bool) error {
+	links, err := r.DB.GetRecordLinks(ctx, j.sync.ID.String(), t.SourceTable)
+	if err != nil {
+		return err
+	}
+	var gone []models.RecordLink
+	for _, l := range links {
+		if l.TombstonedAt == nil && !seen[l.RecordID] {
+			gone = append(gone, l)
+		}
+	}
+	return r.tombstone(ctx, j, t, gone, models.Airtable)
+}
@@ -0,0 +1,40 @@
+package syncer
+
+import (
+	"dbpiper/database/models"
+	"dbpiper/types"
+	"testing"
+)
+
+func TestValidateDeleteMode(t *testing.T) {
+	soft := func(column, field string) types.TableConfig {
+		return types.TableConfig{SourceTable: "people", OnDelete: types.DeleteSoft, SoftDeleteColumn: column, SoftDeleteField: field}
+	}
+
+	tests := []struct {
+		name      string
+		direction models.SyncDirection
+		table     types.TableConfig
+		wantErr   bool
+	}{
+		{"unset", models.Bidirectional, types.TableConfig{}, false},
+		{"ignore", models.Bidirectional, types.TableConfig{OnDelete: types.DeleteIgnore}, false},
+		{"hard", models.Bidirectional, types.TableConfig{OnDelete: types.DeleteHard}, false},
+		{"unknown mode", models.PgToAirtable, types.TableConfig{OnDelete: "archive"}, true},
+		{"soft to airtable", models.PgToAirtable, soft("", "fldDeleted"), false},
+		{"soft to airtable without field", models.PgToAirtable, soft("deleted_at", ""), true},
+		{"soft to postgres", models.AirtableToPg, soft("deleted_at", ""), false},
+		{"soft to postgres without column", models.AirtableToPg, soft("", "fldDeleted"), true},
+		{"soft both ways", models.Bidirectional, soft("deleted_at", "fldDeleted"), false},
+		{"soft both ways without field", models.Bidirectional, soft("deleted_at", ""), true},
+		{"soft both ways without column", models.Bidirectional, soft("", "fldDeleted"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateDeleteMode(tt.direction, tt.table)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateDeleteMode() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
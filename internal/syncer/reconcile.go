@@ -0,0 +1,335 @@
+package syncer
+
+import (
+	"context"
+	"dbpiper/database/models"
+	"dbpiper/internal/airtable"
+	"dbpiper/internal/databases/pgx"
+	"dbpiper/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	pgxv5 "github.com/jackc/pgx/v5"
+)
+
+var ErrConflictResolved = errors.New("conflict already resolved")
+
+// pgSnapshot is the current state of one Postgres row of a mapping.
+type pgSnapshot struct {
+	key       string
+	fields    map[string]any
+	updatedAt time.Time
+}
+
+// reconcile brings both sides of a two way mapping in line. Each side's
+// fingerprint is compared with the one recorded at the last sync: a side
+// that changed is copied over the other, and a record changed on both sides
+// is settled by the sync's conflict policy. A linked row or record missing
+// from its side was deleted there, which is handled per OnDelete.
+//
+// Both tables are read in full, so this is meant for the periodic runs of a
+// two way sync rather than per-change delivery.
+func (r *Runner) reconcile(ctx context.Context, j *job, t types.TableConfig) error {
+	pt, err := r.pgTableFor(ctx, j, t)
+	if err != nil {
+		return err
+	}
+	rows, err := r.readPgTable(ctx, j, t, pt)
+	if err != nil {
+		return err
+	}
+	records, err := r.readAirtableTable(ctx, j, t, pt)
+	if err != nil {
+		return err
+	}
+	links, err := r.DB.GetRecordLinks(ctx, j.sync.ID.String(), t.SourceTable)
+	if err != nil {
+		return err
+	}
+	j.statsFor(t.SourceTable).RowsRead += int64(len(rows) + len(records))
+
+	var toAirtable []airtableRow
+	var toPg []types.Record
+	held := 0
+
+	var goneFromPg, goneFromAirtable []models.RecordLink
+
+	for _, l := range links {
+		if l.TombstonedAt != nil {
+			// the side that survived a delete is not copied back; the
+			// deleted side coming back is new
+			if l.DeletedFrom == models.Pgx {
+				delete(records, l.RecordID)
+			} else {
+				delete(rows, l.PrimaryKey)
+			}
+			continue
+		}
+
+		row, inPg := rows[l.PrimaryKey]
+		rec, inAirtable := records[l.RecordID]
+		delete(rows, l.PrimaryKey)
+		delete(records, l.RecordID)
+		switch {
+		case !inPg:
+			goneFromPg = append(goneFromPg, l)
+			continue
+		case !inAirtable:
+			goneFromAirtable = append(goneFromAirtable, l)
+			continue
+		}
+
+		pgChanged := fingerprint(row.fields, t) != l.PgHash
+		airtableChanged := fingerprint(rec.Fields, t) != l.AirtableHash
+
+		winner := models.RepoType("")
+		switch {
+		case pgChanged && airtableChanged:
+			winner = decideConflict(j.sync.ConflictPolicy, t, row, rec)
+			if winner == "" {
+				if err := r.holdConflict(ctx, j, t, l, row, rec); err != nil {
+					return err
+				}
+				held++
+			}
+		case pgChanged:
+			winner = models.Pgx
+		case airtableChanged:
+			winner = models.Airtable
+		}
+
+		switch winner {
+		case models.Pgx:
+			toAirtable = append(toAirtable, airtableRow{key: row.key, fields: row.fields})
+		case models.Airtable:
+			toPg = append(toPg, rec)
+		}
+	}
+
+	// anything left is not linked yet
+	for _, row := range rows {
+		toAirtable = append(toAirtable, airtableRow{key: row.key, fields: row.fields})
+	}
+	for _, rec := range records {
+		toPg = append(toPg, rec)
+	}
+
+	recordIDs, _, _, err := r.pushToAirtable(ctx, j, t, toAirtable)
+	if err != nil {
+		return err
+	}
+	toPg = notWrittenTo(toPg, recordIDs)
+	if _, _, err := r.pushToPg(ctx, j, t, toPg); err != nil {
+		return err
+	}
+	if err := r.tombstone(ctx, j, t, goneFromPg, models.Pgx); err != nil {
+		return err
+	}
+	if err := r.tombstone(ctx, j, t, goneFromAirtable, models.Airtable); err != nil {
+		return err
+	}
+
+	log.Printf("sync %s: reconciled %s, %d to airtable, %d to postgres, %d deleted, %d conflicts held",
+		j.sync.ID, t.SourceTable, len(toAirtable), len(toPg), len(goneFromPg)+len(goneFromAirtable), held)
+	return nil
+}
+
+// notWrittenTo leaves out of records those just written from Postgres. A
+// row whose mapped key matches a record that was not linked yet is merged
+// into it, and copying the record's old values back would revert the row.
+func notWrittenTo(records []types.Record, recordIDs []string) []types.Record {
+	if len(recordIDs) == 0 {
+		return records
+	}
+	written := make(map[string]bool, len(recordIDs))
+	for _, id := range recordIDs {
+		written[id] = true
+	}
+	return slices.DeleteFunc(records, func(rec types.Record) bool { return written[rec.ID] })
+}
+
+// decideConflict returns the side that wins a record changed on both sides,
+// or "" when the conflict must be held for review. Last writer wins falls
+// back to holding when either modification time is unknown.
+func decideConflict(policy models.ConflictPolicy, t types.TableConfig, row *pgSnapshot, rec types.Record) models.RepoType {
+	switch policy {
+	case models.PostgresWins:
+		return models.Pgx
+	case models.AirtableWins:
+		return models.Airtable
+	case models.ManualReview:
+		return ""
+	}
+
+	if row.updatedAt.IsZero() || t.UpdatedAtField == "" {
+		return ""
+	}
+	s, _ := rec.Fields[t.UpdatedAtField].(string)
+	airtableTime, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return ""
+	}
+	if airtableTime.After(row.updatedAt) {
+		return models.Airtable
+	}
+	return models.Pgx
+}
+
+func (r *Runner) holdConflict(ctx context.Context, j *job, t types.TableConfig, l models.RecordLink, row *pgSnapshot, rec types.Record) error {
+	pgValues, err := json.Marshal(row.fields)
+	if err != nil {
+		return err
+	}
+	airtableValues, err := json.Marshal(rec.Fields)
+	if err != nil {
+		return err
+	}
+	return r.DB.SaveSyncConflict(ctx, &models.SyncConflict{
+		SyncID:         j.sync.ID,
+		SourceTable:    t.SourceTable,
+		PrimaryKey:     l.PrimaryKey,
+		RecordID:       l.RecordID,
+		PgValues:       pgValues,
+		AirtableValues: airtableValues,
+	})
+}
+
+// readPgTable loads every row of the Postgres side of a mapping by key.
+func (r *Runner) readPgTable(ctx context.Context, j *job, t types.TableConfig, pt *pgTable) (map[string]*pgSnapshot, error) {
+	columns := pt.readColumns(t)
+	rows, err := j.pg.Query(ctx, pgx.KeysetQuery(pt.name, columns, pt.key, false))
+	if err != nil {
+		return nil, err
+	}
+	return pt.scanSnapshots(rows, t, columns)
+}
+
+// readColumns are the mapped columns plus the modification time column
+// used for conflicts, if any.
+func (pt *pgTable) readColumns(t types.TableConfig) []string {
+	if t.UpdatedAtColumn != "" && !slices.Contains(pt.columns, t.UpdatedAtColumn) {
+		return append(slices.Clone(pt.columns), t.UpdatedAtColumn)
+	}
+	return pt.columns
+}
+
+// scanSnapshots reads rows selected as key text columns followed by columns.
+func (pt *pgTable) scanSnapshots(rows pgxv5.Rows, t types.TableConfig, columns []string) (map[string]*pgSnapshot, error) {
+	defer rows.Close()
+	updatedAt := slices.Index(columns, t.UpdatedAtColumn)
+
+	snapshots := make(map[string]*pgSnapshot)
+	for rows.Next() {
+		values, err := rows.Values()
+		if err != nil {
+			return nil, err
+		}
+
+		keyValues := make([]string, len(pt.key))
+		for i := range pt.key {
+			keyValues[i], _ = values[i].(string)
+		}
+		values = values[len(pt.key):]
+
+		row := &pgSnapshot{
+			key:    encodeKey(keyValues),
+			fields: make(map[string]any, len(pt.columns)),
+		}
+		for i := range pt.columns {
+			row.fields[pt.fieldIDs[i]] = airtableValue(values[i])
+		}
+		if t.UpdatedAtColumn != "" {
+			row.updatedAt, _ = values[updatedAt].(time.Time)
+		}
+		snapshots[row.key] = row
+	}
+	return snapshots, rows.Err()
+}
+
+// readAirtableTable loads every record of the Airtable side of a mapping by
+// record ID.
+func (r *Runner) readAirtableTable(ctx context.Context, j *job, t types.TableConfig, pt *pgTable) (map[string]types.Record, error) {
+	fields := pt.fieldIDs
+	if t.UpdatedAtField != "" && !slices.Contains(fields, t.UpdatedAtField) {
+		fields = append(slices.Clone(fields), t.UpdatedAtField)
+	}
+
+	params := types.ListRecordsParams{
+		PageSize:              airtable.MaxPageSize,
+		Fields:                fields,
+		ReturnFieldsByFieldID: true,
+	}
+	records := make(map[string]types.Record)
+	for {
+		page, err := j.airtable.ListRecords(ctx, airtableTableOf(j.sync, t), params)
+		if err != nil {
+			return nil, err
+		}
+		for _, rec := range page.Records {
+			records[rec.ID] = rec
+		}
+		if page.Offset == "" {
+			return records, nil
+		}
+		params.Offset = page.Offset
+	}
+}
+
+// ResolveConflict settles a held conflict by writing the winning side's
+// values, as captured when the conflict was held, over the other side.
+func (r *Runner) ResolveConflict(ctx context.Context, syncID string, conflictID int, winner models.RepoType) error {
+	if winner != models.Pgx && winner != models.Airtable {
+		return fmt.Errorf("invalid winner %q", winner)
+	}
+
+	sync, err := r.DB.FindSyncByID(ctx, syncID)
+	if err != nil {
+		return err
+	}
+	conflict, err := r.DB.GetSyncConflictByID(ctx, syncID, conflictID)
+	if err != nil {
+		return err
+	}
+	if conflict.Status != models.ConflictPending {
+		return ErrConflictResolved
+	}
+
+	j, err := r.prepare(ctx, sync)
+	if err != nil {
+		return err
+	}
+	idx := slices.IndexFunc(j.tables, func(t types.TableConfig) bool {
+		return t.SourceTable == conflict.SourceTable
+	})
+	if idx < 0 {
+		return fmt.Errorf("table %s is no longer part of the sync", conflict.SourceTable)
+	}
+	t := j.tables[idx]
+
+	switch winner {
+	case models.Pgx:
+		var fields map[string]any
+		if err := json.Unmarshal(conflict.PgValues, &fields); err != nil {
+			return err
+		}
+		row := airtableRow{key: conflict.PrimaryKey, fields: fields}
+		if _, _, _, err := r.pushToAirtable(ctx, j, t, []airtableRow{row}); err != nil {
+			return err
+		}
+	case models.Airtable:
+		var fields map[string]any
+		if err := json.Unmarshal(conflict.AirtableValues, &fields); err != nil {
+			return err
+		}
+		rec := types.Record{ID: conflict.RecordID, Fields: fields}
+		if _, _, err := r.pushToPg(ctx, j, t, []types.Record{rec}); err != nil {
+			return err
+		}
+	}
+
+	return r.DB.ResolveSyncConflict(ctx, conflictID, winner)
+}
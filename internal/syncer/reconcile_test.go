@@ -0,0 +1,73 @@
+package syncer
+
+import (
+	"dbpiper/database/models"
+	"dbpiper/types"
+	"slices"
+	"testing"
+	"time"
+)
+
+// An unlinked row whose key matches an unlinked record is merged into that
+// record by the upsert. The record must not then be copied back to Postgres
+// with its values from before the merge.
+func TestNotWrittenToDropsMergedRecords(t *testing.T) {
+	toPg := []types.Record{
+		{ID: "recMerged", Fields: map[string]any{"fldName": "old"}},
+		{ID: "recOnlyInAirtable", Fields: map[string]any{"fldName": "kept"}},
+	}
+	// the upsert linked one row to a new record and merged another
+	written := []string{"recNew", "recMerged"}
+
+	got := notWrittenTo(toPg, written)
+
+	ids := make([]string, len(got))
+	for i, rec := range got {
+		ids[i] = rec.ID
+	}
+	if want := []string{"recOnlyInAirtable"}; !slices.Equal(ids, want) {
+		t.Fatalf("records left for Postgres = %v, want %v", ids, want)
+	}
+}
+
+func TestNotWrittenToKeepsAllWhenNothingWritten(t *testing.T) {
+	toPg := []types.Record{{ID: "recA"}, {ID: "recB"}}
+	if got := notWrittenTo(toPg, nil); len(got) != 2 {
+		t.Fatalf("got %d records, want 2", len(got))
+	}
+}
+
+func TestDecideConflict(t *testing.T) {
+	pgTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	table := types.TableConfig{UpdatedAtColumn: "updated_at", UpdatedAtField: "fldModified"}
+	modified := func(s string) types.Record {
+		return types.Record{ID: "recA", Fields: map[string]any{"fldModified": s}}
+	}
+
+	tests := []struct {
+		name   string
+		policy models.ConflictPolicy
+		table  types.TableConfig
+		row    *pgSnapshot
+		rec    types.Record
+		want   models.RepoType
+	}{
+		{"postgres wins", models.PostgresWins, table, &pgSnapshot{}, types.Record{}, models.Pgx},
+		{"airtable wins", models.AirtableWins, table, &pgSnapshot{}, types.Record{}, models.Airtable},
+		{"manual review holds", models.ManualReview, table, &pgSnapshot{updatedAt: pgTime}, modified("2024-03-02T00:00:00Z"), ""},
+		{"airtable newer", models.LastWriterWins, table, &pgSnapshot{updatedAt: pgTime}, modified("2024-03-01T12:00:01Z"), models.Airtable},
+		{"postgres newer", models.LastWriterWins, table, &pgSnapshot{updatedAt: pgTime}, modified("2024-03-01T11:59:59Z"), models.Pgx},
+		{"same time goes to postgres", models.LastWriterWins, table, &pgSnapshot{updatedAt: pgTime}, modified("2024-03-01T12:00:00Z"), models.Pgx},
+		{"no postgres time", models.LastWriterWins, table, &pgSnapshot{}, modified("2024-03-02T00:00:00Z"), ""},
+		{"no airtable time field", models.LastWriterWins, types.TableConfig{UpdatedAtColumn: "updated_at"}, &pgSnapshot{updatedAt: pgTime}, modified("2024-03-02T00:00:00Z"), ""},
+		{"airtable time missing", models.LastWriterWins, table, &pgSnapshot{updatedAt: pgTime}, types.Record{Fields: map[string]any{}}, ""},
+		{"airtable time unparsable", models.LastWriterWins, table, &pgSnapshot{updatedAt: pgTime}, modified("yesterday"), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decideConflict(tt.policy, tt.table, tt.row, tt.rec); got != tt.want {
+				t.Errorf("decideConflict() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
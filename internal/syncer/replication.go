@@ -0,0 +1,229 @@
+package syncer
+
+import (
+	"context"
+	"dbpiper/database/models"
+	"dbpiper/internal/databases/pgx"
+	"dbpiper/types"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+const (
+	// standbyInterval is how often the confirmed position is reported even
+	// when nothing changed; well under the server's wal_sender_timeout.
+	standbyInterval = 10 * time.Second
+	// changes are applied once this many are buffered or the oldest has
+	// waited the coalesce window
+	maxBufferedChanges = 500
+)
+
+// ReplicationSlot is the replication slot a sync owns. Other Postgres
+// objects a sync installs are named after it too.
+func ReplicationSlot(sync *models.Sync) string {
+	return "dbpiper_" + strings.ReplaceAll(sync.ID.String(), "-", "")
+}
+
+// publicationName is the publication of the mapped tables a sync owns.
+func publicationName(sync *models.Sync) string {
+	return ReplicationSlot(sync)
+}
+
+// installReplication publishes the mapped tables and creates the slot the
+// sync streams from. The slot keeps every change made from now on, so
+// changes made while the backfill runs are not lost.
+func (r *Runner) installReplication(ctx context.Context, j *job) error {
+	if j.sync.Direction == models.AirtableToPg {
+		return errors.New("replication capture needs Postgres as the source")
+	}
+	tables := make([]string, len(j.tables))
+	for i, t := range j.tables {
+		tables[i] = pgTableOf(j.sync, t)
+	}
+	if err := pgx.EnsurePublication(ctx, j.pg, publicationName(j.sync), tables); err != nil {
+		return fmt.Errorf("publication: %w", err)
+	}
+	if err := pgx.EnsureSlot(ctx, j.pg, ReplicationSlot(j.sync)); err != nil {
+		return fmt.Errorf("replication slot: %w", err)
+	}
+	return nil
+}
+
+// ReplicationStatus reports how far the slot of a sync lags behind.
+func (r *Runner) ReplicationStatus(ctx context.Context, sync *models.Sync) (*pgx.SlotStatus, error) {
+	pool, err := r.pgPool(ctx, sync)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.GetSlotStatus(ctx, pool, ReplicationSlot(sync))
+}
+
+// changeStream is the state of one replication stream.
+type changeStream struct {
+	conn      *pgconn.PgConn
+	decoder   *pgx.Decoder
+	tables    map[string]types.TableConfig // by Postgres table
+	inTx      bool
+	tx        []types.ChangeEvent // events of the open transaction
+	buffered  []types.ChangeEvent // events of committed transactions
+	bufferEnd pgx.LSN             // end of the last buffered transaction
+	since     time.Time           // when the first buffered event arrived
+	confirmed pgx.LSN
+}
+
+// streamChanges consumes the replication slot of a sync. Committed changes
+// are applied to Airtable in small batches, and their position is
+// confirmed to the server only once Apply succeeded, so a crash replays
+// them rather than losing them.
+func (r *Runner) streamChanges(ctx context.Context, syncID string) error {
+	sync, err := r.DB.FindSyncByID(ctx, syncID)
+	if err != nil {
+		return err
+	}
+	tables, err := DecodeTables(sync.Tables)
+	if err != nil {
+		return err
+	}
+	_, dsn, err := r.pgDSN(ctx, sync)
+	if err != nil {
+		return err
+	}
+
+	conn, err := pgx.ReplicationConnect(ctx, dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if err := pgx.StartReplication(ctx, conn, ReplicationSlot(sync), publicationName(sync), 0); err != nil {
+		return err
+	}
+	log.Printf("capture: streaming changes of sync %s", syncID)
+
+	s := &changeStream{
+		conn:    conn,
+		decoder: pgx.NewDecoder(),
+		tables:  make(map[string]types.TableConfig, len(tables)),
+	}
+	for _, t := range tables {
+		s.tables[pgTableOf(sync, t)] = t
+	}
+
+	nextStatus := time.Now().Add(standbyInterval)
+	for {
+		deadline := nextStatus
+		if len(s.buffered) > 0 {
+			deadline = s.since.Add(r.CoalesceWindow)
+		}
+		receiveCtx, cancel := context.WithDeadline(ctx, deadline)
+		msg, err := conn.ReceiveMessage(receiveCtx)
+		cancel()
+
+		switch {
+		case err == nil:
+		case pgconn.Timeout(err) && ctx.Err() == nil:
+			msg = nil
+		default:
+			return err
+		}
+
+		if msg != nil {
+			if err := s.handle(msg); err != nil {
+				return err
+			}
+		}
+
+		if len(s.buffered) >= maxBufferedChanges || (len(s.buffered) > 0 && time.Since(s.since) >= r.CoalesceWindow) {
+			if err := r.Apply(ctx, syncID, models.QueueSourceDatabase, s.buffered); err != nil {
+				return err
+			}
+			s.buffered = s.buffered[:0]
+			s.confirmed = s.bufferEnd
+			nextStatus = time.Time{}
+		}
+
+		if time.Now().After(nextStatus) {
+			if err := pgx.SendStandbyStatus(ctx, conn, s.confirmed); err != nil {
+				return err
+			}
+			nextStatus = time.Now().Add(standbyInterval)
+		}
+	}
+}
+
+func (s *changeStream) handle(msg pgproto3.BackendMessage) error {
+	switch msg := msg.(type) {
+	case *pgproto3.CopyData:
+		rm, err := pgx.ParseReplicationMessage(msg.Data)
+		if err != nil {
+			return err
+		}
+		if rm.Keepalive {
+			// with nothing in flight, everything the server sent is handled
+			if !s.inTx && len(s.buffered) == 0 && rm.WALEnd > s.confirmed {
+				s.confirmed = rm.WALEnd
+			}
+			if rm.ReplyRequested {
+				return pgx.SendStandbyStatus(context.Background(), s.conn, s.confirmed)
+			}
+			return nil
+		}
+		return s.decode(rm.Data)
+	case *pgproto3.ErrorResponse:
+		return pgconn.ErrorResponseToPgError(msg)
+	case *pgproto3.CopyDone:
+		return errors.New("server ended the replication stream")
+	}
+	return nil
+}
+
+func (s *changeStream) decode(data []byte) error {
+	msg, err := s.decoder.Decode(data)
+	if err != nil {
+		return err
+	}
+
+	switch msg := msg.(type) {
+	case *pgx.Begin:
+		s.inTx = true
+		s.tx = s.tx[:0]
+	case *pgx.Commit:
+		s.inTx = false
+		if len(s.tx) > 0 {
+			if len(s.buffered) == 0 {
+				s.since = time.Now()
+			}
+			s.buffered = append(s.buffered, s.tx...)
+		}
+		s.bufferEnd = msg.EndLSN
+		if len(s.buffered) == 0 {
+			s.confirmed = msg.EndLSN
+		}
+	case *pgx.Change:
+		t, ok := s.tables[msg.Relation.Name]
+		if !ok {
+			return nil
+		}
+		op := types.ChangeUpdate
+		switch msg.Kind {
+		case pgx.Insert:
+			op = types.ChangeCreate
+		case pgx.Delete:
+			op = types.ChangeDelete
+		}
+		for _, key := range msg.Keys() {
+			s.tx = append(s.tx, types.ChangeEvent{Table: t.SourceTable, Op: op, Key: key})
+		}
+	case *pgx.Truncate:
+		for _, rel := range msg.Relations {
+			log.Printf("capture: %s was truncated; its deletes are picked up by the next full run", rel.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,431 @@
+package syncer
+
+import (
+	"context"
+	"database/sql"
+	"dbpiper/database"
+	"dbpiper/database/models"
+	"dbpiper/internal/airtable"
+	"dbpiper/internal/databases/pgx"
+	"dbpiper/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrSyncBusy   = errors.New("sync is already running")
+	ErrSyncPaused = errors.New("sync is paused")
+)
+
+const (
+	// heartbeatInterval is how often a run tells it is still alive.
+	heartbeatInterval = time.Minute
+	// staleRunAfter is how long a run may go without a heartbeat before
+	// another runner assumes its process died and takes over.
+	staleRunAfter = 5 * time.Minute
+)
+
+// runnableStatuses are the states a sync may be started from. An installing
+// sync can only be claimed once the run installing it has died.
+var runnableStatuses = []models.SyncStatus{
+	models.SyncSetup,
+	models.SyncInstalling,
+	models.SyncActive,
+	models.SyncError,
+}
+
+type Runner struct {
+	DB       database.DB
+	PgxPool  *pgx.PoolManager
+	Listener *pgx.Listener
+	// CoalesceWindow is how long changes are collected before they are
+	// delivered, so a burst for the same records costs one write.
+	CoalesceWindow time.Duration
+}
+
+func New(db database.DB, pgxPool *pgx.PoolManager) *Runner {
+	return &Runner{
+		DB:             db,
+		PgxPool:        pgxPool,
+		Listener:       pgx.NewListener(),
+		CoalesceWindow: coalesceWindow(),
+	}
+}
+
+// job holds everything resolved for one execution of a sync.
+type job struct {
+	sync     *models.Sync
+	tables   []types.TableConfig
+	pg       *pgxpool.Pool
+	airtable airtable.Client
+	progress map[string]*models.BackfillProgress
+	pgTables map[string]*pgTable
+
+	stats         map[string]*models.TableRunStats
+	airtableCalls atomic.Int64 // HTTP requests to the API, retries included
+}
+
+// tableFunc moves the data of a single table mapping.
+type tableFunc func(ctx context.Context, j *job, table types.TableConfig) error
+
+// Run executes a sync end to end. A sync that is not active yet goes
+// through setup -> installing -> active; any sync ends in error with
+// LastError recorded if the run fails. Runs are passes over the backfill:
+// an interrupted pass resumes, a completed one starts over. Each run is
+// recorded as a SyncRun with what it did to every table.
+func (r *Runner) Run(ctx context.Context, syncID string, trigger models.RunTrigger) error {
+	sync, err := r.Claim(ctx, syncID)
+	if err != nil {
+		return err
+	}
+	return r.RunClaimed(ctx, sync, trigger)
+}
+
+// Claim takes the run of a sync, failing with ErrSyncPaused or ErrSyncBusy
+// when it cannot run now. It returns the sync as it is once claimed; the
+// caller must go on with RunClaimed, which releases the claim.
+func (r *Runner) Claim(ctx context.Context, syncID string) (*models.Sync, error) {
+	sync, err := r.DB.FindSyncByID(ctx, syncID)
+	if err != nil {
+		return nil, err
+	}
+	if sync.Status == models.SyncPaused {
+		return nil, ErrSyncPaused
+	}
+
+	claimed, err := r.DB.ClaimSyncRun(ctx, syncID, runnableStatuses, time.Now().Add(-staleRunAfter))
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		return nil, ErrSyncBusy
+	}
+	sync, err = r.DB.FindSyncByID(ctx, syncID)
+	if err != nil {
+		// the claim would otherwise only go once it is stale
+		if err := r.DB.FinishSyncRun(context.WithoutCancel(ctx), syncID); err != nil {
+			log.Printf("sync %s: failed to release run: %v", syncID, err)
+		}
+		return nil, err
+	}
+	return sync, nil
+}
+
+// RunClaimed executes a sync claimed with Claim, then releases it.
+func (r *Runner) RunClaimed(ctx context.Context, sync *models.Sync, trigger models.RunTrigger) error {
+	syncID := sync.ID.String()
+	defer func() {
+		if err := r.DB.FinishSyncRun(context.WithoutCancel(ctx), syncID); err != nil {
+			log.Printf("sync %s: failed to release run: %v", syncID, err)
+		}
+	}()
+	defer r.heartbeat(ctx, syncID)()
+
+	run := &models.SyncRun{
+		SyncID:    sync.ID,
+		Trigger:   trigger,
+		Status:    models.RunRunning,
+		StartedAt: time.Now(),
+	}
+	if err := r.DB.CreateSyncRun(ctx, run); err != nil {
+		return err
+	}
+
+	j, err := r.execute(ctx, sync)
+	r.finishRun(ctx, run, j, err)
+	return err
+}
+
+func (r *Runner) execute(ctx context.Context, sync *models.Sync) (*job, error) {
+	syncID := sync.ID.String()
+	installing := sync.Status != models.SyncActive
+	if installing {
+		if err := r.DB.UpdateSyncStatus(ctx, syncID, models.SyncInstalling, sql.NullString{}); err != nil {
+			return nil, err
+		}
+	}
+
+	j, err := r.prepare(ctx, sync)
+	if err != nil {
+		return nil, r.fail(ctx, sync, err)
+	}
+
+	if installing {
+		if err := r.install(ctx, j); err != nil {
+			return j, r.fail(ctx, sync, err)
+		}
+	}
+
+	if j.passCompleted() {
+		if err := r.DB.StartBackfillPass(ctx, syncID); err != nil {
+			return j, r.fail(ctx, sync, err)
+		}
+		for _, p := range j.progress {
+			p.RowsRead, p.RowsWritten, p.Cursor, p.Completed = 0, 0, nil, false
+		}
+	}
+
+	if err := r.transfer(ctx, j); err != nil {
+		return j, r.fail(ctx, sync, err)
+	}
+
+	return j, r.DB.UpdateSyncStatus(ctx, syncID, models.SyncActive, sql.NullString{})
+}
+
+// heartbeat keeps the claim on a sync fresh until the returned func is
+// called.
+func (r *Runner) heartbeat(ctx context.Context, syncID string) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := r.DB.HeartbeatSyncRun(ctx, syncID); err != nil {
+					log.Printf("sync %s: heartbeat failed: %v", syncID, err)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// Running reports whether a run of the sync is in progress and alive.
+func Running(sync *models.Sync) bool {
+	return sync.RunStartedAt != nil &&
+		sync.RunHeartbeatAt != nil &&
+		sync.RunHeartbeatAt.After(time.Now().Add(-staleRunAfter))
+}
+
+// passCompleted reports whether the previous run backfilled every table.
+func (j *job) passCompleted() bool {
+	for _, t := range j.tables {
+		if p, ok := j.progress[t.SourceTable]; !ok || !p.Completed {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Runner) fail(ctx context.Context, sync *models.Sync, cause error) error {
+	log.Printf("sync %s failed: %v", sync.ID, cause)
+	lastError := sql.NullString{String: cause.Error(), Valid: true}
+	if err := r.DB.UpdateSyncStatus(ctx, sync.ID.String(), models.SyncError, lastError); err != nil {
+		return errors.Join(cause, err)
+	}
+	return cause
+}
+
+// prepare decodes the table mapping and opens both ends of the sync.
+func (r *Runner) prepare(ctx context.Context, sync *models.Sync) (*job, error) {
+	tables, err := DecodeTables(sync.Tables)
+	if err != nil {
+		return nil, err
+	}
+	if len(tables) == 0 {
+		return nil, errors.New("sync has no tables configured")
+	}
+
+	j := &job{
+		sync:     sync,
+		tables:   tables,
+		progress: make(map[string]*models.BackfillProgress),
+		pgTables: make(map[string]*pgTable),
+		stats:    make(map[string]*models.TableRunStats),
+	}
+
+	progress, err := r.DB.GetBackfillProgress(ctx, sync.ID.String())
+	if err != nil {
+		return nil, err
+	}
+	for i := range progress {
+		j.progress[progress[i].SourceTable] = &progress[i]
+	}
+
+	j.pg, err = r.pgPool(ctx, sync)
+	if err != nil {
+		return nil, err
+	}
+
+	j.airtable, err = r.airtableClient(ctx, sync, airtable.WithRequestCounter(&j.airtableCalls))
+	if err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// pgDSN returns the ID and connection string of the Postgres side of a
+// sync.
+func (r *Runner) pgDSN(ctx context.Context, sync *models.Sync) (string, string, error) {
+	connID := sync.SourceConnID
+	if sync.SourceType == models.Airtable {
+		connID = sync.TargetConnID
+	}
+	db, err := r.DB.GetDatabaseConnectionByID(ctx, sync.UserID, connID)
+	if err != nil {
+		return "", "", fmt.Errorf("database connection %s: %w", connID, err)
+	}
+	dsn := db.ConnectionURL.String
+	if !db.ConnectionURL.Valid {
+		dsn = pgx.BuildPostgresDSN(db.Username, db.Password, db.Host, strconv.Itoa(db.Port), db.DatabaseName, db.SSLEnabled)
+	}
+	return connID, dsn, nil
+}
+
+func (r *Runner) pgPool(ctx context.Context, sync *models.Sync) (*pgxpool.Pool, error) {
+	connID, dsn, err := r.pgDSN(ctx, sync)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := r.PgxPool.GetPool(ctx, connID, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("database connection %s: %w", connID, err)
+	}
+	return pool, nil
+}
+
+// install checks that every mapped table and field still exists on both
+// sides before any data is moved, then sets up change capture: a webhook
+// on the base when the sync reads from Airtable, and whatever CaptureMode
+// needs in Postgres.
+func (r *Runner) install(ctx context.Context, j *job) error {
+	tables, err := j.airtable.GetTables(ctx)
+	if err != nil {
+		return err
+	}
+	airtableFields := make(map[string]map[string]bool, len(tables))
+	for _, t := range tables {
+		fields := make(map[string]bool, len(t.Fields))
+		for _, f := range t.Fields {
+			fields[f.ID] = true
+		}
+		airtableFields[t.ID] = fields
+	}
+
+	for _, t := range j.tables {
+		pgTable, airtableTable := pgTableOf(j.sync, t), airtableTableOf(j.sync, t)
+
+		fields, ok := airtableFields[airtableTable]
+		if !ok {
+			return fmt.Errorf("airtable table %s not found", airtableTable)
+		}
+
+		if err := ValidateDeleteMode(j.sync.Direction, t); err != nil {
+			return err
+		}
+		if err := ValidateCursorColumn(j.sync.Direction, t); err != nil {
+			return err
+		}
+		if t.CursorColumn != "" {
+			if err := pgx.CheckCursorColumn(ctx, j.pg, pgTable, t.CursorColumn); err != nil {
+				return err
+			}
+		}
+
+		columns := make([]string, 0, len(t.Fields)+1)
+		for column, fieldID := range t.Fields {
+			if !fields[fieldID] {
+				return fmt.Errorf("airtable field %s not found in table %s", fieldID, airtableTable)
+			}
+			columns = append(columns, column)
+		}
+		if t.OnDelete == types.DeleteSoft {
+			if t.SoftDeleteField != "" && !fields[t.SoftDeleteField] {
+				return fmt.Errorf("airtable field %s not found in table %s", t.SoftDeleteField, airtableTable)
+			}
+			if t.SoftDeleteColumn != "" {
+				columns = append(columns, t.SoftDeleteColumn)
+			}
+		}
+
+		rows, err := j.pg.Query(ctx, pgx.SelectQuery(pgTable, columns)+" LIMIT 0")
+		if err != nil {
+			return fmt.Errorf("table %s: %w", pgTable, err)
+		}
+		rows.Close()
+	}
+
+	if readsAirtable(j.sync.Direction) {
+		if err := r.installWebhook(ctx, j); err != nil {
+			return err
+		}
+	}
+
+	switch j.sync.CaptureMode {
+	case models.CaptureReplication:
+		return r.installReplication(ctx, j)
+	case models.CaptureTrigger:
+		return r.installChangelog(ctx, j)
+	}
+	return nil
+}
+
+func (r *Runner) transfer(ctx context.Context, j *job) error {
+	fn, err := r.tableFunc(j.sync.Direction)
+	if err != nil {
+		return err
+	}
+	for _, t := range j.tables {
+		// deletes left over from a failed run go first
+		if err := r.propagateDeletes(ctx, j, t); err != nil {
+			return fmt.Errorf("table %s: %w", t.SourceTable, err)
+		}
+		if err := fn(ctx, j, t); err != nil {
+			return fmt.Errorf("table %s: %w", t.SourceTable, err)
+		}
+	}
+	return nil
+}
+
+func (r *Runner) tableFunc(direction models.SyncDirection) (tableFunc, error) {
+	switch direction {
+	case models.PgToAirtable:
+		return r.backfillPgToAirtable, nil
+	case models.AirtableToPg:
+		return r.backfillAirtableToPg, nil
+	case models.Bidirectional:
+		return r.reconcile, nil
+	}
+	return nil, fmt.Errorf("unknown sync direction %q", direction)
+}
+
+// DecodeTables parses the Tables column of a sync.
+func DecodeTables(raw []byte) ([]types.TableConfig, error) {
+	var tables []types.TableConfig
+	if len(raw) == 0 {
+		return tables, nil
+	}
+	if err := json.Unmarshal(raw, &tables); err != nil {
+		return nil, fmt.Errorf("invalid table mapping: %w", err)
+	}
+	return tables, nil
+}
+
+// pgTableOf returns the Postgres side of a table mapping.
+func pgTableOf(sync *models.Sync, t types.TableConfig) string {
+	if sync.SourceType == models.Airtable {
+		return t.TargetTable
+	}
+	return t.SourceTable
+}
+
+// airtableTableOf returns the Airtable side of a table mapping.
+func airtableTableOf(sync *models.Sync, t types.TableConfig) string {
+	if sync.SourceType == models.Airtable {
+		return t.SourceTable
+	}
+	return t.TargetTable
+}
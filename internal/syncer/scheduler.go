@@ -0,0 +1,157 @@
+package syncer
+
+import (
+	"context"
+	"dbpiper/database/models"
+	"dbpiper/internal/schedule"
+	"dbpiper/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+const schedulerInterval = 30 * time.Second
+
+// scheduledStatuses are the states in which a sync keeps running on its
+// schedule. A failed run does not stop the next one.
+var scheduledStatuses = []models.SyncStatus{
+	models.SyncActive,
+	models.SyncError,
+}
+
+// Scheduler enqueues runs of syncs that have a schedule. Missed runs, e.g.
+// after downtime, collapse into a single run and the next one is computed
+// from the current time. A slot whose previous run is still going is
+// skipped.
+//
+// It also resumes runs that died with their process, so an interrupted
+// backfill carries on from its checkpoint after a crash or deploy, and
+// keeps the Airtable webhooks of syncs from expiring.
+type Scheduler struct {
+	Runner *Runner
+}
+
+func NewScheduler(runner *Runner) *Scheduler {
+	return &Scheduler{Runner: runner}
+}
+
+// ParseSchedule validates a schedule and its timezone. An empty timezone
+// means UTC.
+func ParseSchedule(spec, timezone string) (schedule.Schedule, *time.Location, error) {
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid timezone: %w", err)
+	}
+	sched, err := schedule.Parse(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	if sched.Next(time.Now().In(loc)).IsZero() {
+		return nil, nil, errors.New("schedule never runs")
+	}
+	return sched, loc, nil
+}
+
+// Run checks for due syncs until ctx is cancelled.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(schedulerInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.tick(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("scheduler: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) tick(ctx context.Context) error {
+	now := time.Now()
+	if err := s.resumeInterrupted(ctx, now); err != nil {
+		return err
+	}
+	if err := s.Runner.RefreshWebhooks(ctx, now); err != nil {
+		return err
+	}
+
+	syncs, err := s.Runner.DB.GetScheduledSyncs(ctx, scheduledStatuses, now)
+	if err != nil {
+		return err
+	}
+
+	for _, sync := range syncs {
+		sched, loc, err := ParseSchedule(sync.Schedule, sync.Timezone)
+		if err != nil {
+			log.Printf("scheduler: sync %s: %v", sync.ID, err)
+			continue
+		}
+		next := sched.Next(now.In(loc))
+
+		// only the scheduler that moves next_run_at enqueues the run
+		moved, err := s.Runner.DB.SetSyncNextRun(ctx, sync.ID.String(), sync.NextRunAt, &next)
+		if err != nil {
+			return err
+		}
+		if !moved || sync.NextRunAt == nil {
+			continue
+		}
+
+		if Running(&sync) {
+			log.Printf("scheduler: sync %s still running since %s, skipping", sync.ID, sync.RunStartedAt.Format(time.RFC3339))
+			continue
+		}
+
+		if err := s.enqueueRun(ctx, &sync, models.TriggerSchedule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Scheduler) resumeInterrupted(ctx context.Context, now time.Time) error {
+	syncs, err := s.Runner.DB.GetInterruptedSyncs(ctx, now.Add(-staleRunAfter))
+	if err != nil {
+		return err
+	}
+	for _, sync := range syncs {
+		if sync.Status == models.SyncPaused {
+			continue
+		}
+		// the claim stays stale until a worker takes the run; one queued
+		// run is enough
+		pending, err := s.Runner.DB.HasPendingQueueItem(ctx, sync.ID.String(), models.QueueSourceSchedule)
+		if err != nil {
+			return err
+		}
+		if pending {
+			continue
+		}
+		log.Printf("scheduler: resuming interrupted run of sync %s started at %s", sync.ID, sync.RunStartedAt.Format(time.RFC3339))
+		if err := s.enqueueRun(ctx, &sync, models.TriggerResume); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Scheduler) enqueueRun(ctx context.Context, sync *models.Sync, trigger models.RunTrigger) error {
+	return s.Runner.DB.EnqueueWebhook(ctx, runItem(sync.ID.String(), trigger))
+}
+
+// runItem is the queue row that has a worker run a sync.
+func runItem(syncID string, trigger models.RunTrigger) *models.WebhookQueue {
+	payload, _ := json.Marshal(types.RunPayload{Trigger: trigger})
+	return &models.WebhookQueue{
+		SyncID:      syncID,
+		Source:      models.QueueSourceSchedule,
+		Payload:     datatypes.JSON(payload),
+		MaxAttempts: 1,
+	}
+}
@@ -0,0 +1,56 @@
+package syncer
+
+import (
+	"context"
+	"database/sql"
+	"dbpiper/database/models"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// statsFor returns the run statistics of a table mapping.
+func (j *job) statsFor(table string) *models.TableRunStats {
+	if s, ok := j.stats[table]; ok {
+		return s
+	}
+	s := &models.TableRunStats{Table: table}
+	j.stats[table] = s
+	return s
+}
+
+// countWrites records the outcome of writing total rows of a table; rows
+// neither created nor updated when err is set count as failed.
+func (j *job) countWrites(table string, total, created, updated int, err error) {
+	s := j.statsFor(table)
+	s.RowsCreated += int64(created)
+	s.RowsUpdated += int64(updated)
+	if err != nil {
+		s.RowsFailed += int64(total - created - updated)
+	}
+}
+
+// finishRun stores the outcome and statistics of a run. j is nil when the
+// run failed before the sync could be prepared.
+func (r *Runner) finishRun(ctx context.Context, run *models.SyncRun, j *job, cause error) {
+	now := time.Now()
+	run.FinishedAt = &now
+	run.Status = models.RunSucceeded
+	if cause != nil {
+		run.Status = models.RunFailed
+		run.Error = sql.NullString{String: cause.Error(), Valid: true}
+	}
+
+	stats := []models.TableRunStats{}
+	if j != nil {
+		for _, t := range j.tables {
+			stats = append(stats, *j.statsFor(t.SourceTable))
+		}
+		run.AirtableCalls = j.airtableCalls.Load()
+	}
+	run.Tables, _ = json.Marshal(stats)
+
+	if err := r.DB.UpdateSyncRun(context.WithoutCancel(ctx), run); err != nil {
+		log.Printf("sync %s: failed to record run %d: %v", run.SyncID, run.ID, err)
+	}
+}
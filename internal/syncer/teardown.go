@@ -0,0 +1,122 @@
+package syncer
+
+import (
+	"context"
+	"dbpiper/database/models"
+	"dbpiper/internal/airtable"
+	"dbpiper/internal/databases/pgx"
+	"fmt"
+	"log"
+)
+
+// TeardownReport lists what a teardown removed and what it could not.
+type TeardownReport struct {
+	Removed []string          `json:"removed"`
+	Failed  []TeardownFailure `json:"failed"`
+}
+
+type TeardownFailure struct {
+	Item  string `json:"item"`
+	Error string `json:"error"`
+}
+
+func newTeardownReport() *TeardownReport {
+	return &TeardownReport{Removed: []string{}, Failed: []TeardownFailure{}}
+}
+
+func (rep *TeardownReport) record(item string, err error) {
+	if err != nil {
+		rep.Failed = append(rep.Failed, TeardownFailure{Item: item, Error: err.Error()})
+		return
+	}
+	rep.Removed = append(rep.Removed, item)
+}
+
+// OK reports whether everything was removed.
+func (rep *TeardownReport) OK() bool {
+	return len(rep.Failed) == 0
+}
+
+// Teardown removes what dbpiper installed for a sync: its Airtable webhooks
+// and the replication slot and publication, or the triggers and changelog,
+// of its change capture. It carries on past failures and reports each
+// item. The sync's settings are kept, so installing it again restores
+// everything; a leftover slot keeps WAL on the customer's server, so
+// callers should not forget a sync whose teardown failed.
+func (r *Runner) Teardown(ctx context.Context, sync *models.Sync) *TeardownReport {
+	rep := newTeardownReport()
+	r.teardownWebhook(ctx, sync, rep)
+	r.teardownCapture(ctx, sync, rep)
+	if !rep.OK() {
+		log.Printf("sync %s: teardown left %d items behind: %+v", sync.ID, len(rep.Failed), rep.Failed)
+	}
+	return rep
+}
+
+// UninstallCapture removes what change capture installed in Postgres for a
+// sync, then turns capture off. Capture stays on when anything is left, so
+// the uninstall can be retried. Stopping the consumer is left to Capture,
+// which only runs consumers for syncs that still capture.
+func (r *Runner) UninstallCapture(ctx context.Context, sync *models.Sync) (*TeardownReport, error) {
+	rep := newTeardownReport()
+	r.teardownCapture(ctx, sync, rep)
+	if !rep.OK() {
+		return rep, nil
+	}
+	return rep, r.DB.UpdateSyncCaptureMode(ctx, sync.ID.String(), models.CaptureNone)
+}
+
+func (r *Runner) teardownWebhook(ctx context.Context, sync *models.Sync, rep *TeardownReport) {
+	webhooks, err := r.DB.GetAirtableWebhooks(ctx, sync.ID.String())
+	if err != nil {
+		rep.record("airtable webhooks", err)
+		return
+	}
+	if len(webhooks) == 0 {
+		return
+	}
+
+	client, err := r.airtableClient(ctx, sync)
+	for _, webhook := range webhooks {
+		item := "airtable webhook " + webhook.WebhookID
+		if err != nil {
+			rep.record(item, err)
+			continue
+		}
+		if err := client.DeleteWebhook(ctx, webhook.WebhookID); err != nil && !airtable.IsNotFound(err) {
+			rep.record(item, err)
+			continue
+		}
+		rep.record(item, r.DB.DeleteAirtableWebhook(ctx, webhook.ID))
+	}
+}
+
+func (r *Runner) teardownCapture(ctx context.Context, sync *models.Sync, rep *TeardownReport) {
+	var items []string
+	switch sync.CaptureMode {
+	case models.CaptureNone:
+		return
+	case models.CaptureReplication:
+		items = []string{"replication slot " + ReplicationSlot(sync), "publication " + publicationName(sync)}
+	case models.CaptureTrigger:
+		items = []string{"changelog " + changelogOf(sync).Table}
+	default:
+		rep.record("capture", fmt.Errorf("unknown capture mode %q", sync.CaptureMode))
+		return
+	}
+
+	pool, err := r.pgPool(ctx, sync)
+	if err != nil {
+		for _, item := range items {
+			rep.record(item, err)
+		}
+		return
+	}
+	switch sync.CaptureMode {
+	case models.CaptureReplication:
+		rep.record(items[0], pgx.DropSlot(ctx, pool, ReplicationSlot(sync)))
+		rep.record(items[1], pgx.DropPublication(ctx, pool, publicationName(sync)))
+	case models.CaptureTrigger:
+		rep.record(items[0], pgx.UninstallChangelog(ctx, pool, changelogOf(sync)))
+	}
+}
@@ -0,0 +1,112 @@
+package syncer
+
+import (
+	"crypto/sha256"
+	"dbpiper/types"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// airtableValue converts a value scanned by pgx into something Airtable
+// accepts as a cell value.
+func airtableValue(v any) any {
+	switch val := v.(type) {
+	case nil, bool, string, float32, float64,
+		int, int8, int16, int32, int64, uint8, uint16, uint32, uint64:
+		return val
+	case time.Time:
+		return val.UTC().Format(time.RFC3339Nano)
+	case [16]byte:
+		return uuid.UUID(val).String()
+	case []byte:
+		return string(val)
+	case pgtype.Numeric:
+		f, err := val.Float64Value()
+		if err != nil || !f.Valid {
+			return nil
+		}
+		return f.Float64
+	case []any:
+		out := make([]any, len(val))
+		for i, item := range val {
+			out[i] = airtableValue(item)
+		}
+		return out
+	case map[string]any:
+		b, err := json.Marshal(val)
+		if err != nil {
+			return nil
+		}
+		return string(b)
+	case fmt.Stringer:
+		return val.String()
+	}
+	return fmt.Sprint(v)
+}
+
+// pgValue converts an Airtable cell value into the text form Postgres parses
+// for a column of the given type. Nil stays nil so it is written as NULL.
+func pgValue(v any, columnType string) any {
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return val
+	case bool:
+		return strconv.FormatBool(val)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case []any:
+		if strings.HasSuffix(columnType, "[]") {
+			return pgArrayLiteral(val)
+		}
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return string(b)
+}
+
+// pgArrayLiteral renders a one-dimensional Postgres array literal.
+func pgArrayLiteral(items []any) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, item := range items {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		s, ok := pgValue(item, "").(string)
+		if !ok {
+			b.WriteString("NULL")
+			continue
+		}
+		b.WriteByte('"')
+		b.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// fingerprint hashes the mapped fields of one side of a record so a later
+// run can tell whether that side changed. Absent and null fields hash the
+// same, as Airtable omits empty cells.
+func fingerprint(fields map[string]any, t types.TableConfig) string {
+	mapped := make(map[string]any, len(t.Fields))
+	for _, id := range t.Fields {
+		if v, ok := fields[id]; ok && v != nil {
+			mapped[id] = v
+		}
+	}
+	b, _ := json.Marshal(mapped)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,284 @@
+package syncer
+
+import (
+	"context"
+	"dbpiper/database"
+	"dbpiper/database/models"
+	"dbpiper/internal/airtable"
+	"dbpiper/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// webhookRefreshAhead is how long before it expires a webhook is refreshed.
+// Webhooks live 7 days, so this refreshes them about daily and a day or two
+// of downtime does not lose them.
+const webhookRefreshAhead = 6 * 24 * time.Hour
+
+// errCursorMoved aborts queueing a page of payloads another worker queued.
+var errCursorMoved = errors.New("webhook cursor moved")
+
+// readsAirtable reports whether a sync picks up changes made in Airtable.
+func readsAirtable(direction models.SyncDirection) bool {
+	return direction == models.AirtableToPg || direction == models.Bidirectional
+}
+
+// airtableClient opens the Airtable side of a sync.
+func (r *Runner) airtableClient(ctx context.Context, sync *models.Sync, opts ...airtable.Option) (airtable.Client, error) {
+	connID := sync.TargetConnID
+	if sync.SourceType == models.Airtable {
+		connID = sync.SourceConnID
+	}
+	air, err := r.DB.GetAirtableConnectionByID(ctx, sync.UserID, connID)
+	if err != nil {
+		return nil, fmt.Errorf("airtable connection %s: %w", connID, err)
+	}
+	return airtable.New(&r.DB, air, opts...), nil
+}
+
+// installWebhook creates the webhooks of a sync that reads from Airtable,
+// one on each mapped table, so Airtable only pings for changes to them.
+// Webhooks left from an earlier install are replaced, and those of tables
+// no longer mapped removed, so they always watch the current mapping.
+func (r *Runner) installWebhook(ctx context.Context, j *job) error {
+	webhooks, err := r.DB.GetAirtableWebhooks(ctx, j.sync.ID.String())
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]*models.AirtableWebhook, len(webhooks))
+	for i := range webhooks {
+		existing[webhooks[i].TableID] = &webhooks[i]
+	}
+
+	for _, t := range j.tables {
+		tableID := airtableTableOf(j.sync, t)
+		if err := r.createWebhook(ctx, j.sync, tableID, j.airtable, existing[tableID]); err != nil {
+			return fmt.Errorf("table %s: %w", tableID, err)
+		}
+		delete(existing, tableID)
+	}
+	for _, webhook := range existing {
+		if err := j.airtable.DeleteWebhook(ctx, webhook.WebhookID); err != nil && !airtable.IsNotFound(err) {
+			return fmt.Errorf("delete webhook %s: %w", webhook.WebhookID, err)
+		}
+		if err := r.DB.DeleteAirtableWebhook(ctx, webhook.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createWebhook creates the webhook of one table of sync and stores it in
+// place of existing, which is deleted from Airtable first when given.
+func (r *Runner) createWebhook(ctx context.Context, sync *models.Sync, tableID string, client airtable.Client, existing *models.AirtableWebhook) error {
+	webhook := existing
+	if webhook == nil {
+		webhook = &models.AirtableWebhook{SyncID: sync.ID.String(), TableID: tableID}
+	} else if err := client.DeleteWebhook(ctx, webhook.WebhookID); err != nil && !airtable.IsNotFound(err) {
+		return fmt.Errorf("delete webhook %s: %w", webhook.WebhookID, err)
+	}
+
+	created, err := client.CreateWebhook(ctx, tableID)
+	if err != nil {
+		return fmt.Errorf("create webhook: %w", err)
+	}
+
+	webhook.WebhookID = created.ID
+	webhook.MACSecret = created.MACSecretBase64
+	webhook.ExpiresAt = created.ExpirationTime
+	webhook.Cursor = 1
+	return r.DB.SaveAirtableWebhook(ctx, webhook)
+}
+
+// RefreshWebhooks extends the webhooks that are close to expiring. One that
+// Airtable no longer knows is created again.
+func (r *Runner) RefreshWebhooks(ctx context.Context, now time.Time) error {
+	webhooks, err := r.DB.GetExpiringAirtableWebhooks(ctx, now.Add(webhookRefreshAhead))
+	if err != nil {
+		return err
+	}
+	for i := range webhooks {
+		if err := r.refreshWebhook(ctx, &webhooks[i]); err != nil {
+			log.Printf("sync %s: refreshing webhook %s: %v", webhooks[i].SyncID, webhooks[i].WebhookID, err)
+		}
+	}
+	return nil
+}
+
+func (r *Runner) refreshWebhook(ctx context.Context, webhook *models.AirtableWebhook) error {
+	sync, err := r.DB.FindSyncByID(ctx, webhook.SyncID)
+	if err != nil {
+		return err
+	}
+	client, err := r.airtableClient(ctx, sync)
+	if err != nil {
+		return err
+	}
+
+	expires, err := client.RefreshWebhook(ctx, webhook.WebhookID)
+	if airtable.IsNotFound(err) {
+		log.Printf("sync %s: webhook %s is gone, creating a new one", sync.ID, webhook.WebhookID)
+		return r.createWebhook(ctx, sync, webhook.TableID, client, webhook)
+	}
+	if err != nil {
+		return err
+	}
+	webhook.ExpiresAt = expires
+	return r.DB.SaveAirtableWebhook(ctx, webhook)
+}
+
+// ConsumeWebhook reads the payloads of the webhooks of a sync from their
+// cursors and queues the changes to mapped tables and fields as events.
+// Each page is queued in the transaction that moves the cursor past it, so
+// a failure reads the page again rather than losing it. When payloads were
+// lost, because the cursor fell out of Airtable's 7 day retention or the
+// webhook failed, a run reconciles the tables instead.
+//
+// Pings of a sync are coalesced before they get here, so every webhook of
+// the sync is read; one whose table did not change costs a single request.
+func (r *Runner) ConsumeWebhook(ctx context.Context, syncID string) error {
+	webhooks, err := r.DB.GetAirtableWebhooks(ctx, syncID)
+	if err != nil || len(webhooks) == 0 {
+		// webhooks deleted since the ping have nothing left to read
+		return err
+	}
+	sync, err := r.DB.FindSyncByID(ctx, syncID)
+	if err != nil {
+		return err
+	}
+	if sync.Status == models.SyncPaused {
+		return ErrSyncPaused
+	}
+	tables, err := DecodeTables(sync.Tables)
+	if err != nil {
+		return err
+	}
+	client, err := r.airtableClient(ctx, sync)
+	if err != nil {
+		return err
+	}
+	watched := watchedTables(sync, tables)
+
+	for i := range webhooks {
+		if err := r.consumeWebhook(ctx, client, &webhooks[i], watched); err != nil {
+			return fmt.Errorf("webhook %s: %w", webhooks[i].WebhookID, err)
+		}
+	}
+	return nil
+}
+
+func (r *Runner) consumeWebhook(ctx context.Context, client airtable.Client, webhook *models.AirtableWebhook, watched map[string]webhookTable) error {
+	syncID := webhook.SyncID
+	for {
+		cursor := webhook.Cursor
+		page, err := client.ListWebhookPayloads(ctx, webhook.WebhookID, cursor)
+		if err != nil {
+			return err
+		}
+
+		// the page starts after cursor when older payloads expired
+		lost := page.Cursor-int64(len(page.Payloads)) > cursor
+		var events []types.ChangeEvent
+		for _, p := range page.Payloads {
+			if p.Error {
+				log.Printf("sync %s: webhook %s failed: %s", syncID, webhook.WebhookID, p.Code)
+				lost = true
+				continue
+			}
+			events = append(events, payloadEvents(p, watched)...)
+		}
+
+		err = r.DB.WithTx(func(tx database.DB) error {
+			moved, err := tx.AdvanceWebhookCursor(ctx, webhook.ID, cursor, page.Cursor)
+			if err != nil {
+				return err
+			}
+			if !moved {
+				return errCursorMoved
+			}
+			if lost {
+				return tx.EnqueueWebhook(ctx, runItem(syncID, models.TriggerReconcile))
+			}
+			if len(events) == 0 {
+				return nil
+			}
+			payload, err := json.Marshal(types.ChangePayload{Events: events})
+			if err != nil {
+				return err
+			}
+			return tx.EnqueueWebhook(ctx, &models.WebhookQueue{
+				SyncID:  syncID,
+				Source:  models.QueueSourceAirtable,
+				Payload: datatypes.JSON(payload),
+			})
+		})
+		if errors.Is(err, errCursorMoved) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if lost {
+			log.Printf("sync %s: webhook payloads before %d were lost, reconciling", syncID, page.Cursor)
+		}
+
+		webhook.Cursor = page.Cursor
+		if !page.MightHaveMore {
+			return nil
+		}
+	}
+}
+
+// webhookTable is what the webhooks of a sync watch in one Airtable table.
+type webhookTable struct {
+	source string          // source_table of the mapping
+	fields map[string]bool // mapped field IDs
+}
+
+func watchedTables(sync *models.Sync, tables []types.TableConfig) map[string]webhookTable {
+	watched := make(map[string]webhookTable, len(tables))
+	for _, t := range tables {
+		fields := make(map[string]bool, len(t.Fields)+1)
+		for _, fieldID := range t.Fields {
+			fields[fieldID] = true
+		}
+		if t.SoftDeleteField != "" {
+			fields[t.SoftDeleteField] = true
+		}
+		watched[airtableTableOf(sync, t)] = webhookTable{source: t.SourceTable, fields: fields}
+	}
+	return watched
+}
+
+// payloadEvents turns the changes of a payload into events. Tables that are
+// not mapped are skipped, and so are updates that only touch unmapped
+// fields.
+func payloadEvents(p types.WebhookPayload, watched map[string]webhookTable) []types.ChangeEvent {
+	var events []types.ChangeEvent
+	for tableID, changes := range p.ChangedTablesByID {
+		w, ok := watched[tableID]
+		if !ok {
+			continue
+		}
+		for id := range changes.CreatedRecordsByID {
+			events = append(events, types.ChangeEvent{Table: w.source, Op: types.ChangeCreate, RecordID: id})
+		}
+		for id, change := range changes.ChangedRecordsByID {
+			for fieldID := range change.Current.CellValuesByFieldID {
+				if w.fields[fieldID] {
+					events = append(events, types.ChangeEvent{Table: w.source, Op: types.ChangeUpdate, RecordID: id})
+					break
+				}
+			}
+		}
+		for _, id := range changes.DestroyedRecordIDs {
+			events = append(events, types.ChangeEvent{Table: w.source, Op: types.ChangeDelete, RecordID: id})
+		}
+	}
+	return events
+}
@@ -0,0 +1,203 @@
+package syncer
+
+import (
+	"context"
+	"dbpiper/database"
+	"dbpiper/database/models"
+	"dbpiper/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWorkers = 4
+	pollInterval   = 2 * time.Second
+
+	retryBaseDelay = 10 * time.Second
+	retryMaxDelay  = time.Hour
+	// pausedRetryDelay is how long events of a paused sync wait before they
+	// are looked at again. It does not count as an attempt.
+	pausedRetryDelay = time.Minute
+	// leaseDuration is how long a row is held by the worker handling it.
+	// Handling outlasting it may see the row handled twice, which events
+	// allow.
+	leaseDuration = 10 * time.Minute
+)
+
+var errQueueEmpty = errors.New("queue empty")
+
+// Worker consumes the WebhookQueue. Rows are claimed with FOR UPDATE SKIP
+// LOCKED and leased in a short transaction, so any number of workers across
+// dbpiper instances can run against the same queue without handling a row
+// twice.
+type Worker struct {
+	Runner  *Runner
+	Workers int
+}
+
+func NewWorker(runner *Runner) *Worker {
+	workers, _ := strconv.Atoi(os.Getenv("SYNC_WORKERS"))
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	return &Worker{
+		Runner:  runner,
+		Workers: workers,
+	}
+}
+
+// Run processes the queue until ctx is cancelled.
+func (w *Worker) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for range w.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.loop(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (w *Worker) loop(ctx context.Context) {
+	for {
+		err := w.processNext(ctx)
+		if err == nil {
+			// more may be due, go again straight away
+			continue
+		}
+		if !errors.Is(err, errQueueEmpty) && ctx.Err() == nil {
+			log.Printf("queue worker: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// processNext leases one due row and applies it. The lease is taken in a
+// short transaction that counts the attempt and hides the row until the
+// lease runs out; the work happens after it commits, so no transaction stays
+// open across calls to Airtable or Postgres. The row is then deleted when
+// it succeeded, otherwise a retry is scheduled, or it becomes a dead letter
+// once it is out of attempts. A worker that dies holding a lease leaves the
+// attempt counted, and the row comes back when the lease runs out.
+//
+// Scheduled runs are the exception: they can take hours, so their row is
+// removed when claimed and the run happens after the transaction ends.
+func (w *Worker) processNext(ctx context.Context) error {
+	var item *models.WebhookQueue
+	var ids []int
+	var scheduled string
+	var trigger models.RunTrigger
+	err := w.Runner.DB.WithTx(func(tx database.DB) error {
+		claimed, err := tx.ClaimWebhookQueueItem(ctx)
+		if err != nil {
+			return err
+		}
+		if claimed == nil {
+			return errQueueEmpty
+		}
+
+		if claimed.Source == models.QueueSourceSchedule {
+			var payload types.RunPayload
+			if err := json.Unmarshal(claimed.Payload, &payload); err != nil || payload.Trigger == "" {
+				payload.Trigger = models.TriggerSchedule
+			}
+			scheduled, trigger = claimed.SyncID, payload.Trigger
+			return tx.DeleteWebhookQueueItem(ctx, claimed.ID)
+		}
+
+		// the lease of its last attempt ran out without an outcome
+		if claimed.Attempts >= claimed.MaxAttempts {
+			log.Printf("queue item %d (sync %s): lease of attempt %d expired", claimed.ID, claimed.SyncID, claimed.Attempts)
+			return tx.DeadLetterWebhookQueueItem(ctx, claimed.ID, claimed.Attempts, "lease expired")
+		}
+
+		// a burst of rows for the sync is handled as one
+		ids, err = claimBurst(ctx, tx, claimed)
+		if err != nil {
+			return err
+		}
+		item = claimed
+		return tx.LeaseWebhookQueueItems(ctx, ids, time.Now().Add(leaseDuration))
+	})
+	if err != nil {
+		return err
+	}
+
+	if scheduled != "" {
+		// a run still going or a paused sync simply skips this slot
+		if err := w.Runner.Run(ctx, scheduled, trigger); err != nil && !errors.Is(err, ErrSyncBusy) && !errors.Is(err, ErrSyncPaused) {
+			log.Printf("%s run of sync %s: %v", trigger, scheduled, err)
+		}
+		return nil
+	}
+	if item == nil {
+		return nil
+	}
+	return w.settle(context.WithoutCancel(ctx), item, ids, w.handle(ctx, item))
+}
+
+// settle records the outcome of handling item, which stood for the rows
+// ids. Only item keeps the attempt when it fails; the other rows of its
+// burst go back as they were.
+func (w *Worker) settle(ctx context.Context, item *models.WebhookQueue, ids []int, err error) error {
+	db := w.Runner.DB
+	if err == nil {
+		return db.DeleteWebhookQueueItems(ctx, ids)
+	}
+
+	if others := slices.DeleteFunc(slices.Clone(ids), func(id int) bool { return id == item.ID }); len(others) > 0 {
+		if err := db.ReleaseWebhookQueueItems(ctx, others); err != nil {
+			return err
+		}
+	}
+	if errors.Is(err, ErrSyncPaused) {
+		// waiting on a paused sync does not count as an attempt
+		return db.RetryWebhookQueueItem(ctx, item.ID, item.Attempts, time.Now().Add(pausedRetryDelay), err.Error())
+	}
+
+	attempts := item.Attempts + 1
+	log.Printf("queue item %d (sync %s) failed, attempt %d/%d: %v", item.ID, item.SyncID, attempts, item.MaxAttempts, err)
+	if attempts >= item.MaxAttempts {
+		return db.DeadLetterWebhookQueueItem(ctx, item.ID, attempts, err.Error())
+	}
+	return db.RetryWebhookQueueItem(ctx, item.ID, attempts, time.Now().Add(retryDelay(attempts)), err.Error())
+}
+
+func (w *Worker) handle(ctx context.Context, item *models.WebhookQueue) error {
+	switch item.Source {
+	case models.QueueSourceDatabase, models.QueueSourceAirtable:
+		var payload types.ChangePayload
+		if err := json.Unmarshal(item.Payload, &payload); err != nil {
+			return fmt.Errorf("invalid payload: %w", err)
+		}
+		return w.Runner.Apply(ctx, item.SyncID, item.Source, payload.Events)
+	case models.QueueSourceAirtableWebhook:
+		return w.Runner.ConsumeWebhook(ctx, item.SyncID)
+	}
+	return fmt.Errorf("unknown queue source %q", item.Source)
+}
+
+// retryDelay doubles with every attempt up to retryMaxDelay. Half of the
+// delay is random so rows that failed together do not retry together.
+func retryDelay(attempts int) time.Duration {
+	delay := retryMaxDelay
+	if attempts < 20 {
+		delay = min(retryBaseDelay<<(attempts-1), retryMaxDelay)
+	}
+	half := delay / 2
+	return half + rand.N(half)
+}
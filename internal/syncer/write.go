@@ -0,0 +1,354 @@
+package syncer
+
+import (
+	"context"
+	"dbpiper/database/models"
+	"dbpiper/internal/airtable"
+	"dbpiper/internal/databases/pgx"
+	"dbpiper/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	pgxv5 "github.com/jackc/pgx/v5"
+)
+
+// airtableRow is a Postgres row ready to be written to Airtable.
+type airtableRow struct {
+	key    string
+	fields map[string]any
+}
+
+// pgTable caches what is needed to write one mapped Postgres table.
+type pgTable struct {
+	name        string
+	columns     []string
+	fieldIDs    []string
+	columnTypes map[string]string
+	key         []pgx.KeyColumn
+	insert      string
+	update      string
+	// field IDs of the primary key when every key column is mapped
+	mergeOn []string
+}
+
+// encodeKey turns primary key values into the string stored in RecordLink.
+func encodeKey(values []string) string {
+	b, _ := json.Marshal(values)
+	return string(b)
+}
+
+func decodeKey(key string) ([]string, error) {
+	var values []string
+	if err := json.Unmarshal([]byte(key), &values); err != nil {
+		return nil, fmt.Errorf("invalid record key %q: %w", key, err)
+	}
+	return values, nil
+}
+
+func (r *Runner) pgTableFor(ctx context.Context, j *job, t types.TableConfig) (*pgTable, error) {
+	name := pgTableOf(j.sync, t)
+	if pt, ok := j.pgTables[name]; ok {
+		return pt, nil
+	}
+
+	columnTypes, err := pgx.ColumnTypes(ctx, j.pg, name)
+	if err != nil {
+		return nil, err
+	}
+	key, err := pgx.PrimaryKey(ctx, j.pg, name)
+	if err != nil {
+		return nil, err
+	}
+
+	pt := &pgTable{
+		name:        name,
+		columns:     slices.Sorted(maps.Keys(t.Fields)),
+		columnTypes: columnTypes,
+		key:         key,
+	}
+	pt.fieldIDs = make([]string, len(pt.columns))
+	for i, column := range pt.columns {
+		pt.fieldIDs[i] = t.Fields[column]
+	}
+
+	// rows and records that already exist under a mapped key are taken
+	// over rather than duplicated
+	var conflict []string
+	for _, k := range key {
+		field, ok := t.Fields[k.Name]
+		if !ok {
+			conflict, pt.mergeOn = nil, nil
+			break
+		}
+		conflict = append(conflict, k.Name)
+		pt.mergeOn = append(pt.mergeOn, field)
+	}
+	returning := pgx.Returning(key, pt.columns)
+	pt.insert = pgx.UpsertQuery(name, pt.columns, columnTypes, conflict) + returning
+	pt.update = pgx.UpdateQuery(name, pt.columns, columnTypes, key) + returning
+
+	j.pgTables[name] = pt
+	return pt, nil
+}
+
+// pushToAirtable writes rows to the Airtable table of a mapping. Rows already
+// linked to a record update it; the others are created and linked, or take
+// over the record holding their key if the key is mapped. Rows whose record
+// was deleted in Airtable are left out. It returns the records the rows are
+// now linked to.
+func (r *Runner) pushToAirtable(ctx context.Context, j *job, t types.TableConfig, rows []airtableRow) (recordIDs []string, created, updated int, err error) {
+	defer func() { j.countWrites(t.SourceTable, len(rows), created, updated, err) }()
+	pt, err := r.pgTableFor(ctx, j, t)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	for chunk := range slices.Chunk(rows, airtable.MaxRecordsPerRequest) {
+		keys := make([]string, len(chunk))
+		for i, row := range chunk {
+			keys[i] = row.key
+		}
+		links, err := r.DB.GetRecordLinksByKeys(ctx, j.sync.ID.String(), t.SourceTable, keys)
+		if err != nil {
+			return recordIDs, created, updated, err
+		}
+		linked := make(map[string]models.RecordLink, len(links))
+		for _, l := range links {
+			// a row that comes back after being deleted is a new row
+			if l.TombstonedAt != nil && l.DeletedFrom == models.Pgx {
+				continue
+			}
+			linked[l.PrimaryKey] = l
+		}
+
+		var creates, updates []types.Record
+		var createRows, updateRows []airtableRow
+		for _, row := range chunk {
+			if l, ok := linked[row.key]; ok {
+				// the record was deleted in Airtable and stays deleted
+				if l.TombstonedAt != nil {
+					continue
+				}
+				updates = append(updates, types.Record{ID: l.RecordID, Fields: row.fields})
+				updateRows = append(updateRows, row)
+				continue
+			}
+			creates = append(creates, types.Record{Fields: row.fields})
+			createRows = append(createRows, row)
+		}
+
+		var newLinks []models.RecordLink
+		if len(updates) > 0 {
+			records, err := j.airtable.UpdateRecords(ctx, airtableTableOf(j.sync, t), updates, true)
+			if err != nil {
+				return recordIDs, created, updated, err
+			}
+			newLinks = append(newLinks, r.linksFor(j, t, updateRows, records)...)
+			updated += len(records)
+		}
+		if len(creates) > 0 && len(pt.mergeOn) > 0 {
+			opts := types.UpsertOptions{FieldsToMergeOn: pt.mergeOn, Typecast: true}
+			results, err := j.airtable.UpsertRecords(ctx, airtableTableOf(j.sync, t), creates, opts)
+			if err != nil {
+				return recordIDs, created, updated, err
+			}
+			records := make([]types.Record, len(results))
+			for i, res := range results {
+				records[i] = res.Record
+				if res.Created {
+					created++
+				} else {
+					updated++
+				}
+			}
+			newLinks = append(newLinks, r.linksFor(j, t, createRows, records)...)
+		} else if len(creates) > 0 {
+			records, err := j.airtable.CreateRecords(ctx, airtableTableOf(j.sync, t), creates, true)
+			if err != nil {
+				return recordIDs, created, updated, err
+			}
+			newLinks = append(newLinks, r.linksFor(j, t, createRows, records)...)
+			created += len(records)
+		}
+		if err := r.DB.SaveRecordLinks(ctx, newLinks); err != nil {
+			return recordIDs, created, updated, err
+		}
+		for _, l := range newLinks {
+			recordIDs = append(recordIDs, l.RecordID)
+		}
+	}
+	return recordIDs, created, updated, nil
+}
+
+// linksFor pairs written rows with the records Airtable returned for them,
+// which come back in request order.
+func (r *Runner) linksFor(j *job, t types.TableConfig, rows []airtableRow, records []types.Record) []models.RecordLink {
+	links := make([]models.RecordLink, len(records))
+	for i, rec := range records {
+		links[i] = models.RecordLink{
+			SyncID:       j.sync.ID,
+			SourceTable:  t.SourceTable,
+			PrimaryKey:   rows[i].key,
+			RecordID:     rec.ID,
+			PgHash:       fingerprint(rows[i].fields, t),
+			AirtableHash: fingerprint(rec.Fields, t),
+		}
+	}
+	return links
+}
+
+// pushToPg writes Airtable records to the Postgres table of a mapping.
+// Records already linked to a row update it; the others are inserted and
+// linked. A linked row that no longer exists is inserted again, unless its
+// delete was recorded, in which case the record is left out.
+func (r *Runner) pushToPg(ctx context.Context, j *job, t types.TableConfig, records []types.Record) (created, updated int, err error) {
+	if len(records) == 0 {
+		return 0, 0, nil
+	}
+	defer func() { j.countWrites(t.SourceTable, len(records), created, updated, err) }()
+
+	pt, err := r.pgTableFor(ctx, j, t)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	recordIDs := make([]string, len(records))
+	for i, rec := range records {
+		recordIDs[i] = rec.ID
+	}
+	links, err := r.DB.GetRecordLinksByRecordIDs(ctx, j.sync.ID.String(), t.SourceTable, recordIDs)
+	if err != nil {
+		return 0, 0, err
+	}
+	linked := make(map[string]models.RecordLink, len(links))
+	for _, l := range links {
+		// a record that comes back after being deleted is a new record
+		if l.TombstonedAt != nil && l.DeletedFrom == models.Airtable {
+			continue
+		}
+		linked[l.RecordID] = l
+	}
+
+	var newLinks []models.RecordLink
+	var missing []types.Record
+
+	link := func(rec types.Record, row pgxv5.Row) error {
+		key, fields, err := pt.scanReturning(row)
+		if err != nil {
+			return err
+		}
+		newLinks = append(newLinks, models.RecordLink{
+			SyncID:       j.sync.ID,
+			SourceTable:  t.SourceTable,
+			PrimaryKey:   key,
+			RecordID:     rec.ID,
+			PgHash:       fingerprint(fields, t),
+			AirtableHash: fingerprint(rec.Fields, t),
+		})
+		return nil
+	}
+
+	// a batch runs in one implicit transaction, so its writes only count
+	// once it went through as a whole
+	var batchCreated, batchUpdated int
+	sendBatch := func(batch *pgxv5.Batch) error {
+		batchCreated, batchUpdated = 0, 0
+		if err := j.pg.SendBatch(ctx, batch).Close(); err != nil {
+			return err
+		}
+		created += batchCreated
+		updated += batchUpdated
+		return nil
+	}
+
+	queueInsert := func(batch *pgxv5.Batch, rec types.Record) {
+		batch.Queue(pt.insert, pt.args(rec)...).QueryRow(func(row pgxv5.Row) error {
+			if err := link(rec, row); err != nil {
+				return err
+			}
+			batchCreated++
+			return nil
+		})
+	}
+
+	batch := &pgxv5.Batch{}
+	for _, rec := range records {
+		l, ok := linked[rec.ID]
+		if !ok {
+			queueInsert(batch, rec)
+			continue
+		}
+		// the row was deleted in Postgres and stays deleted
+		if l.TombstonedAt != nil {
+			continue
+		}
+		values, err := decodeKey(l.PrimaryKey)
+		if err != nil {
+			return 0, 0, err
+		}
+		args := pt.args(rec)
+		for _, v := range values {
+			args = append(args, v)
+		}
+		batch.Queue(pt.update, args...).QueryRow(func(row pgxv5.Row) error {
+			if err := link(rec, row); err != nil {
+				if errors.Is(err, pgxv5.ErrNoRows) {
+					missing = append(missing, rec)
+					return nil
+				}
+				return err
+			}
+			batchUpdated++
+			return nil
+		})
+	}
+	if err := sendBatch(batch); err != nil {
+		return created, updated, err
+	}
+
+	if len(missing) > 0 {
+		batch = &pgxv5.Batch{}
+		for _, rec := range missing {
+			queueInsert(batch, rec)
+		}
+		if err := sendBatch(batch); err != nil {
+			return created, updated, err
+		}
+	}
+
+	return created, updated, r.DB.SaveRecordLinks(ctx, newLinks)
+}
+
+// args returns the mapped column values of a record as query parameters.
+func (pt *pgTable) args(rec types.Record) []any {
+	args := make([]any, len(pt.columns), len(pt.columns)+len(pt.key))
+	for i, column := range pt.columns {
+		args[i] = pgValue(rec.Fields[pt.fieldIDs[i]], pt.columnTypes[column])
+	}
+	return args
+}
+
+// scanReturning reads a row produced by the insert or update query: the
+// encoded key plus the mapped values keyed by Airtable field ID.
+func (pt *pgTable) scanReturning(row pgxv5.Row) (string, map[string]any, error) {
+	keyValues := make([]string, len(pt.key))
+	values := make([]any, len(pt.columns))
+	dest := make([]any, 0, len(keyValues)+len(values))
+	for i := range keyValues {
+		dest = append(dest, &keyValues[i])
+	}
+	for i := range values {
+		dest = append(dest, &values[i])
+	}
+	if err := row.Scan(dest...); err != nil {
+		return "", nil, err
+	}
+
+	fields := make(map[string]any, len(values))
+	for i, v := range values {
+		fields[pt.fieldIDs[i]] = airtableValue(v)
+	}
+	return encodeKey(keyValues), fields, nil
+}
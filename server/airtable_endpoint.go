@@ -5,6 +5,7 @@ import (
 	"dbpiper/database/models"
 	"dbpiper/internal/airtable"
 	"dbpiper/types"
+	"errors"
 	"net/http"
 	"time"
 
@@ -24,6 +25,8 @@ func (s *Server) addAirtableEndPoint(g *echo.Group) {
 
 	apikey := airtables.Group("/apikey")
 	apikey.POST("/connect", s.apiKeyConnecter)
+
+	airtables.GET("/throttle/stats", s.getAirtableThrottleStats)
 }
 
 func (s *Server) connectHandler(c echo.Context) error {
@@ -125,12 +128,27 @@ func (s *Server) deleteAirtableConnectionHandler(c echo.Context) error {
 		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "not_authenticated"})
 	}
 	id := c.Param("id")
+
+	// syncs on the base are paused and their webhooks deleted first;
+	// ?force=true deletes even if some are left
+	teardown, clean, err := s.teardownSyncsOf(ctx, userID, models.Airtable, id)
+	if errors.Is(err, errSyncRunning) {
+		return c.JSON(http.StatusConflict, echo.Map{"error": "sync_already_running", "details": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "db_error", "details": err.Error()})
+	}
+	if !clean && c.QueryParam("force") != "true" {
+		return c.JSON(http.StatusBadGateway, echo.Map{"error": "teardown_incomplete", "teardown": teardown})
+	}
+
 	if err := s.DB.DeleteAirtableConnection(ctx, userID, id); err != nil {
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "db_error", "details": err.Error()})
 	}
 
 	return c.JSON(http.StatusOK, echo.Map{
-		"message": "Integration removed from our system. For complete removal, please also revoke access in your Airtable account",
+		"message":  "Integration removed from our system. For complete removal, please also revoke access in your Airtable account",
+		"teardown": teardown,
 	})
 }
 
@@ -159,3 +177,12 @@ func (s *Server) getAirtableTables(c echo.Context) error {
 		"tables": tables,
 	})
 }
+
+// getAirtableThrottleStats reports how often this process was throttled by
+// Airtable and held requests back.
+func (s *Server) getAirtableThrottleStats(c echo.Context) error {
+	if userID, ok := c.Get("user_id").(string); !ok || userID == "" {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "not authenticated"})
+	}
+	return c.JSON(http.StatusOK, airtable.GetThrottleStats())
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"dbpiper/database/models"
+	"dbpiper/internal/syncer"
+	"dbpiper/types"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+func (s *Server) addConflictEndPoint(g *echo.Group) {
+	conflicts := g.Group("/conflicts")
+	conflicts.GET("", s.listConflicts)
+	conflicts.POST("/:conflictId/resolve", s.resolveConflict)
+}
+
+func (s *Server) listConflicts(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID, ok := c.Get("user_id").(string)
+	if !ok || userID == "" {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "not authenticated"})
+	}
+
+	sync, err := s.DB.GetSyncByID(ctx, userID, c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "sync_not_found", "details": err.Error()})
+	}
+
+	status := models.ConflictStatus(c.QueryParam("status"))
+	if status == "" {
+		status = models.ConflictPending
+	}
+	conflicts, err := s.DB.GetSyncConflicts(ctx, sync.ID.String(), status)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "db_error", "details": err.Error()})
+	}
+
+	response := make([]map[string]any, 0, len(conflicts))
+	for _, conflict := range conflicts {
+		response = append(response, map[string]any{
+			"id":              conflict.ID,
+			"table":           conflict.SourceTable,
+			"primary_key":     json.RawMessage(conflict.PrimaryKey),
+			"record_id":       conflict.RecordID,
+			"pg_values":       conflict.PgValues,
+			"airtable_values": conflict.AirtableValues,
+			"status":          conflict.Status,
+			"resolution":      conflict.Resolution,
+			"created_at":      conflict.CreatedAt,
+			"resolved_at":     conflict.ResolvedAt,
+		})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"id":        sync.ID,
+		"conflicts": response,
+	})
+}
+
+func (s *Server) resolveConflict(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID, ok := c.Get("user_id").(string)
+	if !ok || userID == "" {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "not authenticated"})
+	}
+
+	var req types.ResolveConflictRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid_payload", "details": err.Error()})
+	}
+	if req.Winner != models.Pgx && req.Winner != models.Airtable {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid_winner"})
+	}
+
+	conflictID, err := strconv.Atoi(c.Param("conflictId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid_conflict_id"})
+	}
+
+	sync, err := s.DB.GetSyncByID(ctx, userID, c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "sync_not_found", "details": err.Error()})
+	}
+
+	if err := s.Runner.ResolveConflict(ctx, sync.ID.String(), conflictID, req.Winner); err != nil {
+		if errors.Is(err, syncer.ErrConflictResolved) {
+			return c.JSON(http.StatusConflict, echo.Map{"error": "conflict_already_resolved"})
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "conflict_not_found"})
+		}
+		return c.JSON(http.StatusBadGateway, echo.Map{"error": "resolve_failed", "details": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"id":         conflictID,
+		"status":     models.ConflictResolved,
+		"resolution": req.Winner,
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"dbpiper/database/models"
 	"dbpiper/internal/databases/pgx"
 	"dbpiper/types"
+	"errors"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -109,11 +110,24 @@ func (s *Server) deleteDatabaseConnection(c echo.Context) error {
 		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "not authenticated"})
 	}
 
+	// syncs on the database are paused and their slots, publications and
+	// triggers removed first; ?force=true deletes even if some are left
+	teardown, clean, err := s.teardownSyncsOf(ctx, userID, models.Pgx, id)
+	if errors.Is(err, errSyncRunning) {
+		return c.JSON(http.StatusConflict, echo.Map{"error": "sync_already_running", "details": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "db_error", "details": err.Error()})
+	}
+	if !clean && c.QueryParam("force") != "true" {
+		return c.JSON(http.StatusBadGateway, echo.Map{"error": "teardown_incomplete", "teardown": teardown})
+	}
+
 	if err := s.DB.DeleteDatabaseConnection(ctx, userID, id); err != nil {
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "db_error", "details": err.Error()})
 	}
 
-	return c.JSON(http.StatusOK, echo.Map{"message": "Database removed"})
+	return c.JSON(http.StatusOK, echo.Map{"message": "Database removed", "teardown": teardown})
 }
 
 func (s *Server) getTables(c echo.Context) error {
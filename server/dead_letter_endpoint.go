@@ -0,0 +1,170 @@
+package server
+
+import (
+	"dbpiper/database/models"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+func (s *Server) addDeadLetterEndPoint(g *echo.Group) {
+	deadLetters := g.Group("/dead-letters")
+	deadLetters.GET("", s.listDeadLetters)
+	deadLetters.POST("/replay", s.replayDeadLetters)
+
+	one := deadLetters.Group("/:entryId")
+	one.GET("", s.getDeadLetter)
+	one.POST("/replay", s.replayDeadLetter)
+	one.DELETE("", s.deleteDeadLetter)
+}
+
+func deadLetterResponse(item models.WebhookQueue) map[string]any {
+	return map[string]any{
+		"id":         item.ID,
+		"source":     item.Source,
+		"payload":    item.Payload,
+		"attempts":   item.Attempts,
+		"last_error": item.LastError.String,
+		"created_at": item.CreatedAt,
+		"dead_at":    item.DeadAt,
+	}
+}
+
+// listDeadLetters accepts ?table= to only list entries with events for one
+// mapped table.
+func (s *Server) listDeadLetters(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID, ok := c.Get("user_id").(string)
+	if !ok || userID == "" {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "not authenticated"})
+	}
+
+	sync, err := s.DB.GetSyncByID(ctx, userID, c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "sync_not_found", "details": err.Error()})
+	}
+
+	items, err := s.DB.GetDeadLetters(ctx, sync.ID.String(), c.QueryParam("table"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "db_error", "details": err.Error()})
+	}
+
+	response := make([]map[string]any, 0, len(items))
+	for _, item := range items {
+		response = append(response, deadLetterResponse(item))
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"id":           sync.ID,
+		"dead_letters": response,
+	})
+}
+
+func (s *Server) getDeadLetter(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID, ok := c.Get("user_id").(string)
+	if !ok || userID == "" {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "not authenticated"})
+	}
+
+	entryID, err := strconv.Atoi(c.Param("entryId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid_entry_id"})
+	}
+
+	sync, err := s.DB.GetSyncByID(ctx, userID, c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "sync_not_found", "details": err.Error()})
+	}
+
+	item, err := s.DB.GetDeadLetterByID(ctx, sync.ID.String(), entryID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "dead_letter_not_found"})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "db_error", "details": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, deadLetterResponse(*item))
+}
+
+func (s *Server) replayDeadLetter(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID, ok := c.Get("user_id").(string)
+	if !ok || userID == "" {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "not authenticated"})
+	}
+
+	entryID, err := strconv.Atoi(c.Param("entryId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid_entry_id"})
+	}
+
+	sync, err := s.DB.GetSyncByID(ctx, userID, c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "sync_not_found", "details": err.Error()})
+	}
+
+	replayed, err := s.DB.ReplayDeadLetter(ctx, sync.ID.String(), entryID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "db_error", "details": err.Error()})
+	}
+	if !replayed {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "dead_letter_not_found"})
+	}
+
+	return c.JSON(http.StatusAccepted, echo.Map{"replayed": 1})
+}
+
+// replayDeadLetters requeues every dead letter of the sync, or with ?table=
+// only those touching one table, e.g. after its mapping was fixed.
+func (s *Server) replayDeadLetters(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID, ok := c.Get("user_id").(string)
+	if !ok || userID == "" {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "not authenticated"})
+	}
+
+	sync, err := s.DB.GetSyncByID(ctx, userID, c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "sync_not_found", "details": err.Error()})
+	}
+
+	replayed, err := s.DB.ReplayDeadLetters(ctx, sync.ID.String(), c.QueryParam("table"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "db_error", "details": err.Error()})
+	}
+
+	return c.JSON(http.StatusAccepted, echo.Map{"replayed": replayed})
+}
+
+func (s *Server) deleteDeadLetter(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID, ok := c.Get("user_id").(string)
+	if !ok || userID == "" {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "not authenticated"})
+	}
+
+	entryID, err := strconv.Atoi(c.Param("entryId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid_entry_id"})
+	}
+
+	sync, err := s.DB.GetSyncByID(ctx, userID, c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "sync_not_found", "details": err.Error()})
+	}
+
+	deleted, err := s.DB.DeleteDeadLetter(ctx, sync.ID.String(), entryID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "db_error", "details": err.Error()})
+	}
+	if !deleted {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "dead_letter_not_found"})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"message": "Dead letter discarded"})
+}
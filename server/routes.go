@@ -19,13 +19,16 @@ func (s *Server) RegisterRoutes() http.Handler {
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
-	e.Use(JWTMiddleware)
-	v1 := e.Group("/v1")
+	// called by third parties, which authenticate otherwise
+	s.addWebhookEndPoint(e)
+
+	v1 := e.Group("/v1", JWTMiddleware)
 	{
 		s.addAirtableEndPoint(v1)
 		s.addDBConnectionEndPoint(v1)
 		s.addConnectionEndPoint(v1)
 		s.addSyncEndPoint(v1)
+		v1.GET("/webhooks/airtable/stats", s.getWebhookStats)
 	}
 
 	return e
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"dbpiper/database/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultRunsLimit = 50
+	maxRunsLimit     = 500
+)
+
+func (s *Server) addRunEndPoint(g *echo.Group) {
+	runs := g.Group("/runs")
+	runs.GET("", s.listRuns)
+	runs.GET("/:runId", s.getRun)
+}
+
+// listRuns returns the latest runs of a sync, newest first. ?limit caps how
+// many are returned.
+func (s *Server) listRuns(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID, ok := c.Get("user_id").(string)
+	if !ok || userID == "" {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "not authenticated"})
+	}
+
+	sync, err := s.DB.GetSyncByID(ctx, userID, c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "sync_not_found", "details": err.Error()})
+	}
+
+	limit := defaultRunsLimit
+	if l := c.QueryParam("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit <= 0 || limit > maxRunsLimit {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid_limit"})
+		}
+	}
+
+	runs, err := s.DB.GetSyncRuns(ctx, sync.ID.String(), limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "db_error", "details": err.Error()})
+	}
+
+	response := make([]map[string]any, 0, len(runs))
+	for _, run := range runs {
+		response = append(response, runResponse(run))
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"id":   sync.ID,
+		"runs": response,
+	})
+}
+
+func (s *Server) getRun(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID, ok := c.Get("user_id").(string)
+	if !ok || userID == "" {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "not authenticated"})
+	}
+
+	sync, err := s.DB.GetSyncByID(ctx, userID, c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "sync_not_found", "details": err.Error()})
+	}
+
+	runID, err := strconv.Atoi(c.Param("runId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid_run_id"})
+	}
+
+	run, err := s.DB.GetSyncRunByID(ctx, sync.ID.String(), runID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "run_not_found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "db_error", "details": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, runResponse(*run))
+}
+
+func runResponse(run models.SyncRun) map[string]any {
+	tables := json.RawMessage(run.Tables)
+	if len(tables) == 0 {
+		tables = json.RawMessage("[]")
+	}
+	return map[string]any{
+		"id":             run.ID,
+		"trigger":        run.Trigger,
+		"status":         run.Status,
+		"started_at":     run.StartedAt,
+		"finished_at":    run.FinishedAt,
+		"tables":         tables,
+		"airtable_calls": run.AirtableCalls,
+		"error":          run.Error.String,
+	}
+}
@@ -9,12 +9,14 @@ import (
 
 	"dbpiper/database"
 	"dbpiper/internal/databases/pgx"
+	"dbpiper/internal/syncer"
 )
 
 type Server struct {
 	Port    int
 	PgxPool *pgx.PoolManager
 	DB      database.DB
+	Runner  *syncer.Runner
 }
 
 func NewServer(serv *Server) *http.Server {
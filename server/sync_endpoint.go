@@ -2,10 +2,14 @@ package server
 
 import (
 	"context"
+	"database/sql"
 	"dbpiper/database/models"
 	"dbpiper/internal/databases/pgx"
+	"dbpiper/internal/syncer"
 	"dbpiper/types"
 	"encoding/json"
+	"errors"
+	"log"
 	"maps"
 	"net/http"
 	"slices"
@@ -18,6 +22,20 @@ import (
 func (s *Server) addSyncEndPoint(g *echo.Group) {
 	sync := g.Group("/sync")
 	sync.POST("", s.createSync)
+
+	one := sync.Group("/:id")
+	one.GET("", s.getSync)
+	one.DELETE("", s.deleteSync)
+	one.POST("/start", s.startSync)
+	one.POST("/pause", s.pauseSync)
+	one.POST("/resume", s.resumeSync)
+	one.PUT("/schedule", s.updateSchedule)
+	one.POST("/backfill/reset", s.resetBackfill)
+	one.GET("/replication", s.getReplication)
+	one.DELETE("/capture", s.uninstallCapture)
+	s.addConflictEndPoint(one)
+	s.addDeadLetterEndPoint(one)
+	s.addRunEndPoint(one)
 }
 
 func (s *Server) createSync(c echo.Context) error {
@@ -43,27 +61,73 @@ func (s *Server) createSync(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, echo.Map{"error": "no_tables_specified"})
 	}
 
+	policy := models.ConflictPolicy(req.ConflictPolicy)
+	switch policy {
+	case "":
+		policy = models.LastWriterWins
+	case models.LastWriterWins, models.PostgresWins, models.AirtableWins, models.ManualReview:
+	default:
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid_conflict_policy"})
+	}
+
+	if req.Schedule != "" {
+		if _, _, err := syncer.ParseSchedule(req.Schedule, req.Timezone); err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid_schedule", "details": err.Error()})
+		}
+	}
+
 	connID := req.Source.ConnectionID
 	if req.Target.Type == models.Pgx {
 		connID = req.Target.ConnectionID
 	}
 
-	if err := s.validatePgxTableMapping(c, ctx, userID, connID, req.Tables); err != nil {
-		return err
+	if status, body := s.validatePgxTableMapping(ctx, userID, connID, req.Source.Type, req.Tables); body != nil {
+		return c.JSON(status, body)
 	}
 
 	tablesJSON, _ := json.Marshal(req.Tables)
 
+	direction := models.Bidirectional
+	if req.Direction == "one_way" {
+		direction = models.PgToAirtable
+		if req.Source.Type == models.Airtable {
+			direction = models.AirtableToPg
+		}
+	}
+
+	switch req.Capture {
+	case models.CaptureNone:
+	case models.CaptureReplication, models.CaptureTrigger:
+		if direction == models.AirtableToPg {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid_capture", "details": "capture needs Postgres as the source"})
+		}
+	default:
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid_capture"})
+	}
+
+	for _, t := range req.Tables {
+		if err := syncer.ValidateDeleteMode(direction, t); err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid_on_delete", "details": err.Error()})
+		}
+		if err := syncer.ValidateCursorColumn(direction, t); err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid_cursor_column", "details": err.Error()})
+		}
+	}
+
 	sync := models.Sync{
-		ID:           uuid.New(),
-		UserID:       userID,
-		SourceType:   req.Source.Type,
-		SourceConnID: req.Source.ConnectionID,
-		TargetType:   req.Target.Type,
-		TargetConnID: req.Target.ConnectionID,
-		Direction:    req.Direction, //todo fix this 
-		Tables:       tablesJSON,
-		Status:       models.SyncSetup,
+		ID:             uuid.New(),
+		UserID:         userID,
+		SourceType:     req.Source.Type,
+		SourceConnID:   req.Source.ConnectionID,
+		TargetType:     req.Target.Type,
+		TargetConnID:   req.Target.ConnectionID,
+		Direction:      direction,
+		ConflictPolicy: policy,
+		CaptureMode:    req.Capture,
+		Tables:         tablesJSON,
+		Status:         models.SyncSetup,
+		Schedule:       req.Schedule,
+		Timezone:       req.Timezone,
 	}
 	if err := s.DB.CreateSync(ctx, &sync); err != nil {
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "failed_to_create_sync", "details": err.Error()})
@@ -79,15 +143,379 @@ func (s *Server) createSync(c echo.Context) error {
 			"connection_id": sync.TargetConnID,
 			"type":          string(sync.TargetType),
 		},
-		"direction": sync.Direction,
-		"fields":    req.Tables,
+		"direction":       sync.Direction,
+		"conflict_policy": sync.ConflictPolicy,
+		"capture":         sync.CaptureMode,
+		"schedule":        sync.Schedule,
+		"timezone":        sync.Timezone,
+		"fields":          req.Tables,
+	})
+}
+
+func (s *Server) getSync(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID, ok := c.Get("user_id").(string)
+	if !ok || userID == "" {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "not authenticated"})
+	}
+
+	sync, err := s.DB.GetSyncByID(ctx, userID, c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "sync_not_found", "details": err.Error()})
+	}
+
+	tables, err := syncer.DecodeTables(sync.Tables)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "invalid_sync", "details": err.Error()})
+	}
+
+	progress, err := s.DB.GetBackfillProgress(ctx, sync.ID.String())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "db_error", "details": err.Error()})
+	}
+	backfill := make([]map[string]any, 0, len(progress))
+	for _, p := range progress {
+		backfill = append(backfill, map[string]any{
+			"table":        p.SourceTable,
+			"rows_read":    p.RowsRead,
+			"rows_written": p.RowsWritten,
+			"cursor":       p.Cursor,
+			"completed":    p.Completed,
+			"updated_at":   p.UpdatedAt,
+		})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"id": sync.ID,
+		"source": map[string]string{
+			"connection_id": sync.SourceConnID,
+			"type":          string(sync.SourceType),
+		},
+		"target": map[string]string{
+			"connection_id": sync.TargetConnID,
+			"type":          string(sync.TargetType),
+		},
+		"direction":       sync.Direction,
+		"conflict_policy": sync.ConflictPolicy,
+		"capture":         sync.CaptureMode,
+		"schedule":        sync.Schedule,
+		"timezone":        sync.Timezone,
+		"next_run_at":     sync.NextRunAt,
+		"fields":          tables,
+		"status":          sync.Status,
+		"running":         syncer.Running(sync),
+		"last_error":      sync.LastError.String,
+		"backfill":        backfill,
+		"updated_at":      sync.UpdatedAt,
 	})
 }
 
-func (s *Server) validatePgxTableMapping(c echo.Context, ctx context.Context, userID, connID string, tables []types.TableConfig) error {
+// startSync runs the sync in the background; progress is visible through
+// the status returned by getSync.
+func (s *Server) startSync(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID, ok := c.Get("user_id").(string)
+	if !ok || userID == "" {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "not authenticated"})
+	}
+
+	sync, err := s.DB.GetSyncByID(ctx, userID, c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "sync_not_found", "details": err.Error()})
+	}
+
+	// claimed here so the answer says whether it runs and from what state
+	claimed, err := s.Runner.Claim(ctx, sync.ID.String())
+	switch {
+	case errors.Is(err, syncer.ErrSyncBusy):
+		return c.JSON(http.StatusConflict, echo.Map{"error": "sync_already_running"})
+	case errors.Is(err, syncer.ErrSyncPaused):
+		return c.JSON(http.StatusConflict, echo.Map{"error": "sync_paused"})
+	case err != nil:
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "db_error", "details": err.Error()})
+	}
+
+	go func() {
+		if err := s.Runner.RunClaimed(context.Background(), claimed, models.TriggerManual); err != nil {
+			log.Printf("sync %s: %v", claimed.ID, err)
+		}
+	}()
+
+	return c.JSON(http.StatusAccepted, echo.Map{
+		"id":     claimed.ID,
+		"status": claimed.Status,
+	})
+}
+
+// pauseSync stops a sync from running and tears down what it installed,
+// reporting anything that could not be removed. Pausing a paused sync
+// tries again; resuming installs everything anew.
+func (s *Server) pauseSync(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID, ok := c.Get("user_id").(string)
+	if !ok || userID == "" {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "not authenticated"})
+	}
+
+	sync, err := s.DB.GetSyncByID(ctx, userID, c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "sync_not_found", "details": err.Error()})
+	}
+	if syncer.Running(sync) {
+		return c.JSON(http.StatusConflict, echo.Map{"error": "sync_already_running"})
+	}
+
+	if err := s.DB.UpdateSyncStatus(ctx, sync.ID.String(), models.SyncPaused, sql.NullString{}); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "db_error", "details": err.Error()})
+	}
+	teardown := s.Runner.Teardown(ctx, sync)
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"id":       sync.ID,
+		"status":   models.SyncPaused,
+		"teardown": teardown,
+	})
+}
+
+// resumeSync installs a paused sync again and starts a run in the
+// background.
+func (s *Server) resumeSync(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID, ok := c.Get("user_id").(string)
+	if !ok || userID == "" {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "not authenticated"})
+	}
+
+	sync, err := s.DB.GetSyncByID(ctx, userID, c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "sync_not_found", "details": err.Error()})
+	}
+	if sync.Status != models.SyncPaused {
+		return c.JSON(http.StatusConflict, echo.Map{"error": "sync_not_paused"})
+	}
+
+	if err := s.DB.UpdateSyncStatus(ctx, sync.ID.String(), models.SyncSetup, sql.NullString{}); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "db_error", "details": err.Error()})
+	}
+
+	go func(id string) {
+		if err := s.Runner.Run(context.Background(), id, models.TriggerManual); err != nil {
+			log.Printf("sync %s: %v", id, err)
+		}
+	}(sync.ID.String())
+
+	return c.JSON(http.StatusAccepted, echo.Map{
+		"id":     sync.ID,
+		"status": models.SyncInstalling,
+	})
+}
+
+// deleteSync tears down what a sync installed, then deletes the sync and
+// everything recorded about it. A sync whose teardown left anything behind
+// is kept, so it can be retried, unless ?force=true.
+func (s *Server) deleteSync(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID, ok := c.Get("user_id").(string)
+	if !ok || userID == "" {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "not authenticated"})
+	}
+
+	sync, err := s.DB.GetSyncByID(ctx, userID, c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "sync_not_found", "details": err.Error()})
+	}
+	if syncer.Running(sync) {
+		return c.JSON(http.StatusConflict, echo.Map{"error": "sync_already_running"})
+	}
+
+	teardown := s.Runner.Teardown(ctx, sync)
+	if !teardown.OK() && c.QueryParam("force") != "true" {
+		return c.JSON(http.StatusBadGateway, echo.Map{"error": "teardown_incomplete", "teardown": teardown})
+	}
+	if err := s.DB.DeleteSync(ctx, userID, sync.ID.String()); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "failed_to_delete_sync", "details": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"message": "Sync removed", "teardown": teardown})
+}
+
+// errSyncRunning is returned when a connection in use by a running sync is
+// deleted.
+var errSyncRunning = errors.New("a sync using the connection is running")
+
+// teardownSyncsOf pauses the syncs that use a connection about to be
+// deleted and tears down what they installed, while the connection still
+// works. It returns the report of each sync and whether all are clean.
+func (s *Server) teardownSyncsOf(ctx context.Context, userID string, repo models.RepoType, connID string) (map[string]*syncer.TeardownReport, bool, error) {
+	syncs, err := s.DB.GetSyncsByConnection(ctx, userID, repo, connID)
+	if err != nil {
+		return nil, false, err
+	}
+	for i := range syncs {
+		if syncer.Running(&syncs[i]) {
+			return nil, false, errSyncRunning
+		}
+	}
+
+	reports := make(map[string]*syncer.TeardownReport, len(syncs))
+	ok := true
+	for i := range syncs {
+		sync := &syncs[i]
+		reason := sql.NullString{String: "connection deleted", Valid: true}
+		if err := s.DB.UpdateSyncStatus(ctx, sync.ID.String(), models.SyncPaused, reason); err != nil {
+			return nil, false, err
+		}
+		rep := s.Runner.Teardown(ctx, sync)
+		reports[sync.ID.String()] = rep
+		ok = ok && rep.OK()
+	}
+	return reports, ok, nil
+}
+
+// updateSchedule replaces the schedule of a sync. The next run is computed
+// from the new schedule by the scheduler.
+func (s *Server) updateSchedule(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID, ok := c.Get("user_id").(string)
+	if !ok || userID == "" {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "not authenticated"})
+	}
+	var req types.UpdateScheduleRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid_payload", "details": err.Error()})
+	}
+
+	if req.Schedule != "" {
+		if _, _, err := syncer.ParseSchedule(req.Schedule, req.Timezone); err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid_schedule", "details": err.Error()})
+		}
+	}
+
+	sync, err := s.DB.GetSyncByID(ctx, userID, c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "sync_not_found", "details": err.Error()})
+	}
+	if err := s.DB.UpdateSyncSchedule(ctx, userID, sync.ID.String(), req.Schedule, req.Timezone); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "failed_to_update_schedule", "details": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"id":       sync.ID,
+		"schedule": req.Schedule,
+		"timezone": req.Timezone,
+	})
+}
+
+// resetBackfill discards the backfill checkpoints of a sync, or of one table
+// with ?table, so the next run copies from the start.
+func (s *Server) resetBackfill(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID, ok := c.Get("user_id").(string)
+	if !ok || userID == "" {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "not authenticated"})
+	}
+
+	sync, err := s.DB.GetSyncByID(ctx, userID, c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "sync_not_found", "details": err.Error()})
+	}
+	if syncer.Running(sync) {
+		return c.JSON(http.StatusConflict, echo.Map{"error": "sync_already_running"})
+	}
+
+	table := c.QueryParam("table")
+	if table != "" {
+		tables, err := syncer.DecodeTables(sync.Tables)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "invalid_sync", "details": err.Error()})
+		}
+		if !slices.ContainsFunc(tables, func(t types.TableConfig) bool { return t.SourceTable == table }) {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "unknown_table"})
+		}
+	}
+
+	if err := s.DB.ResetBackfillProgress(ctx, sync.ID.String(), table); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "db_error", "details": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"id":    sync.ID,
+		"table": table,
+		"reset": true,
+	})
+}
+
+// getReplication reports the replication slot of a sync that captures
+// changes through logical replication, with how far it lags behind.
+func (s *Server) getReplication(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID, ok := c.Get("user_id").(string)
+	if !ok || userID == "" {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "not authenticated"})
+	}
+
+	sync, err := s.DB.GetSyncByID(ctx, userID, c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "sync_not_found", "details": err.Error()})
+	}
+	if sync.CaptureMode != models.CaptureReplication {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "sync_not_replicated"})
+	}
+
+	status, err := s.Runner.ReplicationStatus(ctx, sync)
+	if errors.Is(err, pgx.ErrNoSlot) {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "slot_not_found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "replication_error", "details": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"id":            sync.ID,
+		"slot":          syncer.ReplicationSlot(sync),
+		"active":        status.Active,
+		"current_lsn":   status.CurrentLSN,
+		"confirmed_lsn": status.ConfirmedLSN,
+		"lag_bytes":     status.LagBytes,
+	})
+}
+
+// uninstallCapture turns change capture off for a sync and removes the
+// slot, publication, triggers or changelog it installed in Postgres.
+func (s *Server) uninstallCapture(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID, ok := c.Get("user_id").(string)
+	if !ok || userID == "" {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "not authenticated"})
+	}
+
+	sync, err := s.DB.GetSyncByID(ctx, userID, c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "sync_not_found", "details": err.Error()})
+	}
+	teardown, err := s.Runner.UninstallCapture(ctx, sync)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "failed_to_uninstall_capture", "details": err.Error()})
+	}
+	if !teardown.OK() {
+		return c.JSON(http.StatusBadGateway, echo.Map{"error": "teardown_incomplete", "teardown": teardown})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"id":       sync.ID,
+		"capture":  models.CaptureNone,
+		"teardown": teardown,
+	})
+}
+
+// validatePgxTableMapping checks that the Postgres side of every mapped
+// table can be read with its mapped columns; for a sync from Airtable that
+// is the target table, as in pgTableOf. It returns the response to send
+// when a check fails, nil otherwise.
+func (s *Server) validatePgxTableMapping(ctx context.Context, userID, connID string, sourceType models.RepoType, tables []types.TableConfig) (int, echo.Map) {
 	db, err := s.DB.GetDatabaseConnectionByID(ctx, userID, connID)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid_source_connection", "details": err.Error()})
+		return http.StatusBadRequest, echo.Map{"error": "invalid_source_connection", "details": err.Error()}
 	}
 	dsn := db.ConnectionURL.String
 	if !db.ConnectionURL.Valid {
@@ -95,19 +523,23 @@ func (s *Server) validatePgxTableMapping(c echo.Context, ctx context.Context, us
 	}
 	pgxPool, err := s.PgxPool.GetPool(ctx, connID, dsn)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "pool error", "details": err.Error()})
+		return http.StatusInternalServerError, echo.Map{"error": "pool error", "details": err.Error()}
 	}
 
 	for _, t := range tables {
-		keys := slices.Collect(maps.Keys(t.Fields))
-		rows, err := pgxPool.Query(ctx, pgx.SelectQuery(t.SourceTable, keys))
+		// the keys of Fields are Postgres columns in either direction
+		table := t.SourceTable
+		if sourceType == models.Airtable {
+			table = t.TargetTable
+		}
+		rows, err := pgxPool.Query(ctx, pgx.SelectQuery(table, slices.Collect(maps.Keys(t.Fields))))
 		if err != nil {
-			return c.JSON(http.StatusBadRequest, echo.Map{
+			return http.StatusBadRequest, echo.Map{
 				"error":   "invalid table",
 				"details": err.Error(),
-			})
+			}
 		}
-		defer rows.Close()
+		rows.Close()
 	}
-	return nil
+	return 0, nil
 }
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"dbpiper/database/models"
+	"dbpiper/internal/airtable"
+	"dbpiper/types"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// maxPingSize caps the body read from a webhook ping; real pings are tiny.
+const maxPingSize = 64 << 10
+
+// pingStats counts webhook pings by outcome since the process started.
+var pingStats struct {
+	accepted atomic.Int64
+	unknown  atomic.Int64
+	invalid  atomic.Int64
+}
+
+// addWebhookEndPoint registers the public endpoints third parties notify.
+// They sit outside JWTMiddleware and check their own signatures.
+func (s *Server) addWebhookEndPoint(e *echo.Echo) {
+	e.POST("/v1/webhooks/airtable", s.receiveAirtableWebhook)
+}
+
+// receiveAirtableWebhook verifies a ping against the MAC secret of its
+// webhook and queues the sync to read the new payloads. It answers as soon
+// as the ping is queued; Airtable only waits a few seconds.
+func (s *Server) receiveAirtableWebhook(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, maxPingSize))
+	if err != nil {
+		pingStats.invalid.Add(1)
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid_payload", "details": err.Error()})
+	}
+	var ping types.WebhookPing
+	if err := json.Unmarshal(body, &ping); err != nil || ping.Webhook.ID == "" {
+		pingStats.invalid.Add(1)
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid_payload"})
+	}
+
+	webhook, err := s.DB.GetAirtableWebhookByWebhookID(ctx, ping.Webhook.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		pingStats.unknown.Add(1)
+		log.Printf("airtable webhook: ping for unknown webhook %s", ping.Webhook.ID)
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "unknown_webhook"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "db_error", "details": err.Error()})
+	}
+
+	if !airtable.VerifyMAC(webhook.MACSecret, body, c.Request().Header.Get(airtable.MACHeader)) {
+		pingStats.invalid.Add(1)
+		log.Printf("airtable webhook: invalid MAC on ping for webhook %s", ping.Webhook.ID)
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "invalid_mac"})
+	}
+
+	// held back for the coalesce window so the pings of a burst of edits
+	// are served by one read of the payloads
+	due := time.Now().Add(s.Runner.CoalesceWindow)
+	if err := s.DB.EnqueueWebhook(ctx, &models.WebhookQueue{
+		SyncID:      webhook.SyncID,
+		Source:      models.QueueSourceAirtableWebhook,
+		Payload:     datatypes.JSON(body),
+		NextRetryAt: &due,
+	}); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "db_error", "details": err.Error()})
+	}
+	pingStats.accepted.Add(1)
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// getWebhookStats reports how many webhook pings this process accepted and
+// rejected.
+func (s *Server) getWebhookStats(c echo.Context) error {
+	if userID, ok := c.Get("user_id").(string); !ok || userID == "" {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "not authenticated"})
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"accepted": pingStats.accepted.Load(),
+		"unknown":  pingStats.unknown.Load(),
+		"invalid":  pingStats.invalid.Load(),
+	})
+}
@@ -2,6 +2,7 @@ package types
 
 import (
 	"dbpiper/database/models"
+	"time"
 )
 
 type APIKeyConnectRequest struct {
@@ -42,6 +43,119 @@ type Field struct {
 	Type string `json:"type"`
 }
 
+type Record struct {
+	ID          string         `json:"id,omitempty"`
+	CreatedTime string         `json:"createdTime,omitempty"`
+	Fields      map[string]any `json:"fields"`
+}
+
+type RecordPage struct {
+	Records []Record `json:"records"`
+	Offset  string   `json:"offset,omitempty"`
+}
+
+// Webhook is an Airtable webhook as returned when it is created.
+type Webhook struct {
+	ID              string    `json:"id"`
+	MACSecretBase64 string    `json:"macSecretBase64"`
+	ExpirationTime  time.Time `json:"expirationTime"`
+}
+
+// WebhookPing is the body Airtable posts to a webhook's notification URL.
+type WebhookPing struct {
+	Base struct {
+		ID string `json:"id"`
+	} `json:"base"`
+	Webhook struct {
+		ID string `json:"id"`
+	} `json:"webhook"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// WebhookPayloads is one page of the payloads of an Airtable webhook.
+type WebhookPayloads struct {
+	// Cursor of the payload after this page
+	Cursor        int64            `json:"cursor"`
+	MightHaveMore bool             `json:"mightHaveMore"`
+	Payloads      []WebhookPayload `json:"payloads"`
+}
+
+// WebhookPayload is what changed in one transaction on a base. A payload
+// with Error set reports that the webhook failed instead.
+type WebhookPayload struct {
+	Timestamp         time.Time                      `json:"timestamp"`
+	ChangedTablesByID map[string]WebhookTableChanges `json:"changedTablesById"`
+	Error             bool                           `json:"error"`
+	Code              string                         `json:"code"`
+}
+
+type WebhookTableChanges struct {
+	CreatedRecordsByID map[string]WebhookRecord       `json:"createdRecordsById"`
+	ChangedRecordsByID map[string]WebhookRecordChange `json:"changedRecordsById"`
+	DestroyedRecordIDs []string                       `json:"destroyedRecordIds"`
+}
+
+type WebhookRecord struct {
+	CellValuesByFieldID map[string]any `json:"cellValuesByFieldId"`
+}
+
+// WebhookRecordChange holds the cells of a record that changed.
+type WebhookRecordChange struct {
+	Current WebhookRecord `json:"current"`
+}
+
+// UpsertOptions configure an upsert of records.
+type UpsertOptions struct {
+	// Fields, by name or ID, whose values identify a record. Records
+	// without an ID that match none are created.
+	FieldsToMergeOn []string
+	Typecast        bool
+}
+
+// UpsertedRecord is a record as an upsert left it.
+type UpsertedRecord struct {
+	Record
+	Created bool
+}
+
+type ListRecordsParams struct {
+	PageSize              int
+	Offset                string
+	Fields                []string
+	FilterByFormula       string
+	ReturnFieldsByFieldID bool
+
+	// View limits and orders records as the named view does
+	View string
+	Sort []SortField
+	// CellFormat is "json" (the default) or "string", which needs TimeZone
+	// and UserLocale to format dates and numbers
+	CellFormat string
+	TimeZone   string
+	UserLocale string
+}
+
+// GetRecordParams are the options of fetching a single record.
+type GetRecordParams struct {
+	ReturnFieldsByFieldID bool
+	CellFormat            string
+	TimeZone              string
+	UserLocale            string
+}
+
+type SortDirection string
+
+const (
+	SortAsc  SortDirection = "asc"
+	SortDesc SortDirection = "desc"
+)
+
+// SortField orders listed records by a field name or ID.
+type SortField struct {
+	Field     string
+	Direction SortDirection // asc when empty
+}
+
 type DBConnectRequest struct {
 	Engine        string `json:"engine"`
 	ConnectionURL string `json:"connection_url"` // optional
@@ -61,6 +175,20 @@ type CreateSyncRequest struct {
 
 	Direction string `json:"direction"` // one_way | two_way
 	Tables    []TableConfig        `json:"tables"`
+
+	ConflictPolicy string `json:"conflict_policy"` // two_way only
+
+	// Optional Postgres change capture between runs: replication or trigger
+	Capture models.CaptureMode `json:"capture"`
+
+	// Optional; "@every 15m", "@daily" or a cron expression like "0 2 * * *"
+	Schedule string `json:"schedule"`
+	Timezone string `json:"timezone"` // IANA name, defaults to UTC
+}
+
+type UpdateScheduleRequest struct {
+	Schedule string `json:"schedule"` // empty removes the schedule
+	Timezone string `json:"timezone"`
 }
 
 type SyncEndpoint struct {
@@ -73,4 +201,59 @@ type TableConfig struct {
 	TargetTable string            `json:"target_table"`
 	Fields      map[string]string `json:"fields"`
 	// source_column -> target_field_id
+
+	// Modification times compared by the last_writer_wins conflict policy
+	UpdatedAtColumn string `json:"updated_at_column,omitempty"`
+	UpdatedAtField  string `json:"updated_at_field,omitempty"` // last modified time field ID
+
+	// What a delete on one side does to the other, ignore by default.
+	// Soft deletes stamp SoftDeleteColumn (a timestamp) in Postgres and
+	// tick SoftDeleteField (a checkbox field ID) in Airtable.
+	OnDelete         DeleteMode `json:"on_delete,omitempty"`
+	SoftDeleteColumn string     `json:"soft_delete_column,omitempty"`
+	SoftDeleteField  string     `json:"soft_delete_field,omitempty"`
+
+	// Optional timestamp column set on every write, or "xmin". Once the
+	// table is backfilled, runs of a Postgres to Airtable sync only read
+	// the rows changed since the previous run.
+	CursorColumn string `json:"cursor_column,omitempty"`
+}
+
+type DeleteMode string
+
+const (
+	DeleteIgnore DeleteMode = "ignore"
+	DeleteHard   DeleteMode = "hard"
+	DeleteSoft   DeleteMode = "soft"
+)
+
+type ResolveConflictRequest struct {
+	Winner models.RepoType `json:"winner"` // pgx | airtable
+}
+
+type ChangeOp string
+
+const (
+	ChangeCreate ChangeOp = "create"
+	ChangeUpdate ChangeOp = "update"
+	ChangeDelete ChangeOp = "delete"
+)
+
+// ChangeEvent tells a sync that a record changed on one side. It only
+// identifies the record; the current values are read when it is applied.
+type ChangeEvent struct {
+	Table    string   `json:"table"` // source_table of the mapping
+	Op       ChangeOp `json:"op"`
+	Key      []string `json:"key,omitempty"`       // Postgres primary key, as text
+	RecordID string   `json:"record_id,omitempty"` // Airtable record
+}
+
+// ChangePayload is the Payload of a WebhookQueue row.
+type ChangePayload struct {
+	Events []ChangeEvent `json:"events"`
+}
+
+// RunPayload is the Payload of a WebhookQueue row that starts a sync run.
+type RunPayload struct {
+	Trigger models.RunTrigger `json:"trigger"`
 }